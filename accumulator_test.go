@@ -0,0 +1,100 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestAccumulator tests that an Accumulator produces the same root as a Tree
+// when the leaves are inserted in a random order.
+func TestAccumulator(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	for numLeaves := 1; numLeaves < 70; numLeaves++ {
+		data := fastrand.Bytes(numLeaves * leafSize)
+		expRoot := bytesRoot(data, h, leafSize)
+		for n := 0; n < 5; n++ {
+			acc := NewAccumulator(h)
+			for j, i := range fastrand.Perm(numLeaves) {
+				if j%2 == 0 {
+					err := acc.Insert(uint64(i), data[i*leafSize:][:leafSize])
+					if err != nil {
+						t.Fatal(err)
+					}
+				} else {
+					err := acc.InsertLeafHash(uint64(i), leafSum(h, data[i*leafSize:][:leafSize]))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				// the ranges should be sorted and separated by gaps, and
+				// each range holds at most two subtrees of every height
+				for k, cr := range acc.ranges {
+					if k > 0 && acc.ranges[k-1].End() >= cr.Begin() {
+						t.Fatal("ranges are not separated by gaps:", acc.ranges[k-1].End(), cr.Begin())
+					} else if len(cr.stack) > 2*64 {
+						t.Fatal("range holds too many subtrees")
+					}
+				}
+			}
+			root, err := acc.Root()
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(root, expRoot) {
+				t.Fatalf("wrong root for %v leaves", numLeaves)
+			}
+			// the range should hold the same subtrees as a Tree
+			tree := New(h)
+			if err := tree.ReadAll(bytes.NewReader(data), leafSize); err != nil {
+				t.Fatal(err)
+			} else if len(acc.ranges[0].stack) != len(tree.stack) {
+				t.Fatal("complete subtrees were not folded")
+			}
+		}
+	}
+}
+
+// TestAccumulatorErrors tests that an Accumulator rejects duplicate leaves
+// and has no root while leaves are missing.
+func TestAccumulatorErrors(t *testing.T) {
+	h := sha256.New()
+	acc := NewAccumulator(h)
+	if root, err := acc.Root(); root != nil || err != nil {
+		t.Fatal("expected nil root for an empty Accumulator")
+	}
+	for _, i := range []uint64{3, 1, 5, 4} {
+		if err := acc.Insert(i, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, i := range []uint64{1, 3, 4, 5} {
+		if err := acc.Insert(i, []byte{byte(i)}); err == nil {
+			t.Fatalf("expected error when inserting leaf %v twice", i)
+		}
+	}
+	if _, err := acc.Root(); err == nil {
+		t.Fatal("expected error while leaves 0 and 2 are missing")
+	}
+	if err := acc.Insert(2, []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acc.Root(); err == nil {
+		t.Fatal("expected error while leaf 0 is missing")
+	}
+	if err := acc.Insert(0, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	tree := New(h)
+	for i := 0; i < 6; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	if root, err := acc.Root(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(root, tree.Root()) {
+		t.Fatal("wrong root")
+	}
+}
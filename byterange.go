@@ -0,0 +1,130 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"io"
+)
+
+// A ByteRangeProof proves that a range of bytes is part of the data of a
+// Merkle tree. Since leaves can only be hashed as a whole, the proof
+// includes the bytes of the first and last leaves of the range that lie
+// outside of the range.
+type ByteRangeProof struct {
+	// Prefix holds the bytes of the first leaf that precede the range, and
+	// Suffix the bytes of the last leaf that follow it. Suffix is shorter
+	// than a leaf if the last leaf of the range is the short final leaf of
+	// the data.
+	Prefix []byte
+	Suffix []byte
+
+	// Hashes holds the leaf-range proof for the leaves that contain the
+	// range, as produced by BuildMultiRangeProofWithSize.
+	Hashes [][]byte
+}
+
+// byteRangeLeaves returns the range of leaves that contains the byte range
+// [off, off+n) of data of the specified size, along with the number of
+// leaves in the tree. It returns ErrInvalidRange if the byte range is empty
+// or negative, and ErrRangeOutOfBounds if it extends past the end of the
+// data.
+func byteRangeLeaves(size int64, leafSize int, off, n int64) (LeafRange, uint64, error) {
+	if off < 0 || n <= 0 || leafSize <= 0 {
+		return LeafRange{}, 0, ErrInvalidRange
+	} else if off+n > size || off+n < off {
+		return LeafRange{}, 0, ErrRangeOutOfBounds
+	}
+	ls := int64(leafSize)
+	r := LeafRange{
+		Start: uint64(off / ls),
+		End:   uint64((off + n + ls - 1) / ls),
+	}
+	numLeaves := uint64((size + ls - 1) / ls)
+	return r, numLeaves, nil
+}
+
+// BuildByteRangeProof constructs a proof for the bytes [off, off+n) of the
+// data of the specified size read from r, which is split into leaves of
+// leafSize bytes, the last of which may be shorter. The proof can be verified
+// with VerifyByteRangeProof.
+func BuildByteRangeProof(r io.ReaderAt, size int64, leafSize int, off, n int64, h hash.Hash) (ByteRangeProof, error) {
+	lr, numLeaves, err := byteRangeLeaves(size, leafSize, off, n)
+	if err != nil {
+		return ByteRangeProof{}, err
+	}
+	leafStart := int64(lr.Start) * int64(leafSize)
+	leafEnd := int64(lr.End) * int64(leafSize)
+	if leafEnd > size {
+		leafEnd = size
+	}
+
+	proof := ByteRangeProof{
+		Prefix: make([]byte, off-leafStart),
+		Suffix: make([]byte, leafEnd-(off+n)),
+	}
+	if err := readAt(r, proof.Prefix, leafStart); err != nil {
+		return ByteRangeProof{}, err
+	}
+	if err := readAt(r, proof.Suffix, off+n); err != nil {
+		return ByteRangeProof{}, err
+	}
+	// The leaves of the range are skipped rather than read, and only
+	// complete leaves can be skipped, so if the range contains the partial
+	// final leaf, the data is treated as ending on a leaf boundary.
+	hsize := size
+	if lr.End == numLeaves {
+		hsize = int64(numLeaves) * int64(leafSize)
+	}
+	sh := NewReaderAtSubtreeHasher(r, hsize, leafSize, h)
+	proof.Hashes, err = BuildMultiRangeProofWithSize([]LeafRange{lr}, sh, numLeaves)
+	if err != nil {
+		return ByteRangeProof{}, err
+	}
+	return proof, nil
+}
+
+// VerifyByteRangeProof verifies a proof produced by BuildByteRangeProof,
+// showing that data holds the bytes [off, off+len(data)) of the data of the
+// specified size in the Merkle tree with the specified root. It returns data
+// if the proof is valid, and ErrInvalidProof otherwise. It returns
+// ErrInvalidRange or ErrRangeOutOfBounds if the range is invalid, and an
+// error if the prefix or suffix of the proof does not have the length
+// required to complete the boundary leaves.
+func VerifyByteRangeProof(h hash.Hash, root []byte, size int64, leafSize int, off int64, data []byte, proof ByteRangeProof) ([]byte, error) {
+	n := int64(len(data))
+	lr, numLeaves, err := byteRangeLeaves(size, leafSize, off, n)
+	if err != nil {
+		return nil, err
+	}
+	leafStart := int64(lr.Start) * int64(leafSize)
+	leafEnd := int64(lr.End) * int64(leafSize)
+	if leafEnd > size {
+		leafEnd = size
+	}
+	if int64(len(proof.Prefix)) != off-leafStart || int64(len(proof.Suffix)) != leafEnd-(off+n) {
+		return nil, errors.New("prefix or suffix has the wrong length")
+	}
+
+	// Rebuild the leaves of the range and hash them.
+	leaves := make([]byte, 0, leafEnd-leafStart)
+	leaves = append(leaves, proof.Prefix...)
+	leaves = append(leaves, data...)
+	leaves = append(leaves, proof.Suffix...)
+	leafHashes := make([][]byte, 0, lr.End-lr.Start)
+	for len(leaves) > 0 {
+		leaf := leaves
+		if len(leaf) > leafSize {
+			leaf = leaf[:leafSize]
+		}
+		leafHashes = append(leafHashes, leafSum(h, leaf))
+		leaves = leaves[len(leaf):]
+	}
+
+	ok, err := VerifyMultiRangeProofWithSize(NewCachedLeafHasher(leafHashes), h, []LeafRange{lr}, proof.Hashes, root, numLeaves)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInvalidProof
+	}
+	return data, nil
+}
@@ -0,0 +1,111 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// eofReaderAt is an io.ReaderAt that returns io.EOF when a read reaches the
+// end of the data, even if it fills the buffer.
+type eofReaderAt struct {
+	r *bytes.Reader
+}
+
+func (r eofReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(b, off)
+	if err == nil && off+int64(n) == r.r.Size() {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// TestByteRangeProof tests that proofs for random byte ranges can be built
+// and verified, including ranges that end in the short final leaf.
+func TestByteRangeProof(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	for numLeaves := 1; numLeaves < 30; numLeaves++ {
+		data := fastrand.Bytes(numLeaves*leafSize - fastrand.Intn(leafSize))
+		size := int64(len(data))
+		root := bytesRoot(data, h, leafSize)
+		for i := 0; i < 10; i++ {
+			off := int64(fastrand.Intn(len(data)))
+			n := 1 + int64(fastrand.Intn(len(data)-int(off)))
+			proof, err := BuildByteRangeProof(bytes.NewReader(data), size, leafSize, off, n, h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// io.ReaderAt implementations may return io.EOF along with a
+			// full read at the end of the data
+			if eofProof, err := BuildByteRangeProof(eofReaderAt{bytes.NewReader(data)}, size, leafSize, off, n, h); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(eofProof, proof) {
+				t.Fatal("proofs differ when the reader returns io.EOF with a full read")
+			}
+			if len(proof.Prefix) != int(off%leafSize) {
+				t.Fatal("wrong prefix length:", len(proof.Prefix))
+			}
+			got, err := VerifyByteRangeProof(h, root, size, leafSize, off, data[off:off+n], proof)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got, data[off:off+n]) {
+				t.Fatal("VerifyByteRangeProof returned the wrong data")
+			}
+
+			// altered data or edges must be rejected
+			bad := append([]byte(nil), data[off:off+n]...)
+			bad[fastrand.Intn(len(bad))] ^= 1
+			if _, err := VerifyByteRangeProof(h, root, size, leafSize, off, bad, proof); err != ErrInvalidProof {
+				t.Fatal("expected ErrInvalidProof for altered data, got", err)
+			}
+			if len(proof.Suffix) > 0 {
+				badProof := proof
+				badProof.Suffix = append([]byte(nil), proof.Suffix...)
+				badProof.Suffix[0] ^= 1
+				if _, err := VerifyByteRangeProof(h, root, size, leafSize, off, data[off:off+n], badProof); err != ErrInvalidProof {
+					t.Fatal("expected ErrInvalidProof for an altered suffix, got", err)
+				}
+			}
+			badProof := proof
+			badProof.Prefix = append(proof.Prefix, 0)
+			if _, err := VerifyByteRangeProof(h, root, size, leafSize, off, data[off:off+n], badProof); err == nil {
+				t.Fatal("expected error for a prefix of the wrong length")
+			}
+			// a range that includes the end of the data binds its size
+			if off+n == size {
+				if _, err := VerifyByteRangeProof(h, root, size+1, leafSize, off, data[off:off+n], proof); err == nil {
+					t.Fatal("expected error for the wrong size")
+				}
+			}
+		}
+	}
+}
+
+// TestByteRangeProofErrors tests that invalid byte ranges are rejected.
+func TestByteRangeProofErrors(t *testing.T) {
+	h := sha256.New()
+	data := fastrand.Bytes(100)
+	r := bytes.NewReader(data)
+	for _, test := range []struct {
+		off, n int64
+		err    error
+	}{
+		{-1, 10, ErrInvalidRange},
+		{0, 0, ErrInvalidRange},
+		{50, -1, ErrInvalidRange},
+		{95, 10, ErrRangeOutOfBounds},
+		{100, 1, ErrRangeOutOfBounds},
+	} {
+		if _, err := BuildByteRangeProof(r, 100, 8, test.off, test.n, h); err != test.err {
+			t.Errorf("BuildByteRangeProof(%v, %v): expected %v, got %v", test.off, test.n, test.err, err)
+		}
+	}
+	if _, err := VerifyByteRangeProof(h, nil, 100, 8, 100, []byte{1}, ByteRangeProof{}); err != ErrRangeOutOfBounds {
+		t.Error("expected ErrRangeOutOfBounds, got", err)
+	}
+}
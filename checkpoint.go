@@ -0,0 +1,281 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// checkpointVersion is the version of the encoding produced by
+// (*Tree).MarshalBinary. It is the first byte of every checkpoint.
+const checkpointVersion = 1
+
+// A checkpointEncoder appends the fields of a checkpoint to a byte slice.
+// Integers are encoded as 8-byte little-endian values, and byte slices are
+// prefixed with their length. The same encoding is used for proofs.
+type checkpointEncoder []byte
+
+func (e *checkpointEncoder) writeBool(b bool) {
+	if b {
+		*e = append(*e, 1)
+	} else {
+		*e = append(*e, 0)
+	}
+}
+
+func (e *checkpointEncoder) writeUint64(u uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], u)
+	*e = append(*e, buf[:]...)
+}
+
+func (e *checkpointEncoder) writePrefixedBytes(b []byte) {
+	e.writeUint64(uint64(len(b)))
+	*e = append(*e, b...)
+}
+
+// A checkpointDecoder reads the fields written by a checkpointEncoder. After
+// the first error, all reads return zero values, so that the error only needs
+// to be checked once all fields have been read.
+type checkpointDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *checkpointDecoder) readBytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.err = errors.New("encoded data is too short")
+		return nil
+	}
+	b := append([]byte(nil), d.buf[:n]...)
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *checkpointDecoder) readBool() bool {
+	b := d.readBytes(1)
+	if d.err == nil && b[0] > 1 {
+		d.err = errors.New("encoded data contains an invalid boolean")
+	}
+	return d.err == nil && b[0] == 1
+}
+
+func (d *checkpointDecoder) readUint64() uint64 {
+	b := d.readBytes(8)
+	if d.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (d *checkpointDecoder) readPrefixedBytes() []byte {
+	return d.readBytes(d.readUint64())
+}
+
+// validateStack checks that a stack of subtree heights, ordered from the
+// largest subtree to the smallest, is consistent with the number of leaves in
+// the tree and with the length of the proof set. These are the invariants that
+// Push and joinAllSubTrees check when DEBUG is enabled.
+func validateStack(heights []int, currentIndex, proofIndex uint64, proofSetLen int) error {
+	var leaves uint64
+	proofHeight := -1
+	for i, height := range heights {
+		if height < 0 || height >= 64 {
+			return fmt.Errorf("invalid subtree height %v", height)
+		}
+		if i > 0 && height >= heights[i-1] {
+			return errors.New("subtrees are out of order")
+		}
+		// Heights are strictly decreasing and below 64, so the number of
+		// leaves cannot overflow.
+		if leaves <= proofIndex && proofIndex-leaves < 1<<uint(height) {
+			proofHeight = height
+		}
+		leaves += 1 << uint(height)
+	}
+	if leaves != currentIndex {
+		return errors.New("subtrees do not add up to the number of leaves")
+	}
+	// The proof set contains the proof leaf followed by one hash for every
+	// level of the subtree containing the proof index.
+	if proofSetLen != proofHeight+1 {
+		return errors.New("proof set does not match the subtree containing the proof index")
+	}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The returned checkpoint
+// contains the full state of the Tree, including any proof in progress, and
+// can be restored with UnmarshalBinary to continue pushing leaves. The hash
+// function itself is not part of the checkpoint. Trees that are building a
+// multi-range proof, see SetRanges, can't be checkpointed.
+func (t *Tree) MarshalBinary() ([]byte, error) {
+	if t.proofRanges != nil {
+		return nil, errors.New("cannot checkpoint a Tree with proof ranges")
+	}
+	e := checkpointEncoder{checkpointVersion}
+	e.writeBool(t.proofTree)
+	e.writeBool(t.cachedTree)
+	e.writeUint64(uint64(t.hash.Size()))
+	e.writeUint64(t.currentIndex)
+	e.writeUint64(t.proofIndex)
+
+	// Write the subtrees starting from the largest, which is the bottom of
+	// the stack.
+	e.writeUint64(uint64(len(t.stack)))
+	for _, st := range t.stack {
+		e.writeUint64(uint64(st.height))
+		e.writePrefixedBytes(st.sum)
+	}
+
+	e.writeUint64(uint64(len(t.proofSet)))
+	for _, p := range t.proofSet {
+		e.writePrefixedBytes(p)
+	}
+	return e, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The Tree must have
+// been created by the same constructor, with the same kind of hash, as the
+// Tree that produced the checkpoint; e.g. a checkpoint of a Tree created by
+// New(sha256.New()) is restored with:
+//
+//		t := New(sha256.New())
+//		err := t.UnmarshalBinary(checkpoint)
+//
+// The checkpoint is validated before it is applied, and the Tree is left
+// unchanged if an error is returned.
+func (t *Tree) UnmarshalBinary(b []byte) error {
+	if t.hash == nil {
+		return errors.New("cannot restore a checkpoint into a Tree without a hash")
+	}
+	d := checkpointDecoder{buf: b}
+	version := d.readBytes(1)
+	if d.err == nil && version[0] != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %v", version[0])
+	}
+	proofTree := d.readBool()
+	cachedTree := d.readBool()
+	hashSize := d.readUint64()
+	currentIndex := d.readUint64()
+	proofIndex := d.readUint64()
+
+	// A tree can never have more than 64 subtrees, and the proof set can
+	// never hold more than 65 elements. Checking these limits up front
+	// prevents large allocations when decoding garbage.
+	numSubTrees := d.readUint64()
+	if d.err == nil && numSubTrees > 64 {
+		return errors.New("checkpoint contains too many subtrees")
+	}
+	stack := make([]subTree, 0, numSubTrees)
+	heights := make([]int, 0, numSubTrees)
+	for i := uint64(0); i < numSubTrees && d.err == nil; i++ {
+		height := d.readUint64()
+		sum := d.readPrefixedBytes()
+		if height >= 64 {
+			return fmt.Errorf("invalid subtree height %v", height)
+		}
+		heights = append(heights, int(height))
+		stack = append(stack, subTree{
+			height: int(height),
+			sum:    sum,
+		})
+	}
+	numProofs := d.readUint64()
+	if d.err == nil && numProofs > 65 {
+		return errors.New("checkpoint contains too many proof elements")
+	}
+	var proofSet [][]byte
+	for i := uint64(0); i < numProofs && d.err == nil; i++ {
+		proofSet = append(proofSet, d.readPrefixedBytes())
+	}
+	if d.err != nil {
+		return d.err
+	} else if len(d.buf) != 0 {
+		return errors.New("checkpoint has trailing bytes")
+	}
+
+	// Check that the checkpoint matches the Tree and is internally
+	// consistent.
+	if cachedTree != t.cachedTree {
+		return errors.New("checkpoint does not match the type of the Tree")
+	}
+	if hashSize != uint64(t.hash.Size()) {
+		return fmt.Errorf("checkpoint was created with a hash of size %v, not %v", hashSize, t.hash.Size())
+	}
+	if err := validateStack(heights, currentIndex, proofIndex, len(proofSet)); err != nil {
+		return err
+	}
+	if !cachedTree {
+		// Every sum and every proof element other than the leaf data is a
+		// hash. A cached tree uses the data pushed by the caller as its
+		// leaves, so the same does not apply there.
+		for _, st := range stack {
+			if uint64(len(st.sum)) != hashSize {
+				return errors.New("checkpoint contains a subtree with an invalid sum")
+			}
+		}
+		for i := 1; i < len(proofSet); i++ {
+			if uint64(len(proofSet[i])) != hashSize {
+				return errors.New("checkpoint contains an invalid proof element")
+			}
+		}
+	}
+
+	t.stack = stack
+	t.currentIndex = currentIndex
+	t.proofIndex = proofIndex
+	t.proofSet = proofSet
+	t.proofTree = proofTree
+	// A checkpoint never holds proof ranges, so discard any ranges the Tree
+	// was proving before it was restored.
+	t.proofRanges = nil
+	t.rangeIndex = 0
+	t.rangeLeaf = 0
+	t.rangeHeight = 0
+	t.rangeProof = nil
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The checkpoint contains
+// the state of the underlying Tree followed by the state of the CachedTree.
+func (ct *CachedTree) MarshalBinary() ([]byte, error) {
+	b, err := ct.Tree.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	e := checkpointEncoder(b)
+	e.writeUint64(ct.cachedNodeHeight)
+	e.writeUint64(ct.trueProofIndex)
+	return e, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The CachedTree must
+// have been created by NewCachedTree with the same kind of hash and the same
+// cachedNodeHeight as the CachedTree that produced the checkpoint. The
+// CachedTree is left unchanged if an error is returned.
+func (ct *CachedTree) UnmarshalBinary(b []byte) error {
+	if len(b) < 16 {
+		return errors.New("checkpoint is too short")
+	}
+	d := checkpointDecoder{buf: b[len(b)-16:]}
+	cachedNodeHeight := d.readUint64()
+	trueProofIndex := d.readUint64()
+	if cachedNodeHeight != ct.cachedNodeHeight {
+		return fmt.Errorf("checkpoint was created with a node height of %v, not %v", cachedNodeHeight, ct.cachedNodeHeight)
+	}
+
+	tree := ct.Tree
+	if err := tree.UnmarshalBinary(b[:len(b)-16]); err != nil {
+		return err
+	}
+	if tree.proofTree && trueProofIndex/(1<<ct.cachedNodeHeight) != tree.proofIndex {
+		return errors.New("checkpoint contains an inconsistent proof index")
+	}
+	ct.Tree = tree
+	ct.trueProofIndex = trueProofIndex
+	return nil
+}
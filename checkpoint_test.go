@@ -0,0 +1,212 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+	"golang.org/x/crypto/blake2b"
+)
+
+// TestTreeCheckpoint tests that a Tree restored from a checkpoint produces the
+// same roots and proofs as the original Tree.
+func TestTreeCheckpoint(t *testing.T) {
+	const numLeaves = 37
+	leaves := make([][]byte, numLeaves)
+	for i := range leaves {
+		leaves[i] = fastrand.Bytes(1 + fastrand.Intn(64))
+	}
+	for split := 0; split <= numLeaves; split++ {
+		for _, proofIndex := range []int{-1, 0, 5, 16, numLeaves - 1} {
+			tree := New(sha256.New())
+			if proofIndex >= 0 {
+				if err := tree.SetIndex(uint64(proofIndex)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, leaf := range leaves[:split] {
+				tree.Push(leaf)
+			}
+			checkpoint, err := tree.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored := New(sha256.New())
+			if err := restored.UnmarshalBinary(checkpoint); err != nil {
+				t.Fatal(err)
+			}
+			for _, leaf := range leaves[split:] {
+				tree.Push(leaf)
+				restored.Push(leaf)
+			}
+			if !bytes.Equal(tree.Root(), restored.Root()) {
+				t.Fatalf("restored tree has wrong root (split %v, index %v)", split, proofIndex)
+			}
+			if proofIndex >= 0 {
+				root1, proof1, index1, n1 := tree.Prove()
+				root2, proof2, index2, n2 := restored.Prove()
+				if !bytes.Equal(root1, root2) || !reflect.DeepEqual(proof1, proof2) || index1 != index2 || n1 != n2 {
+					t.Fatalf("restored tree has wrong proof (split %v, index %v)", split, proofIndex)
+				}
+				if !VerifyProof(sha256.New(), root2, proof2, index2, n2) {
+					t.Fatalf("restored tree produced invalid proof (split %v, index %v)", split, proofIndex)
+				}
+			}
+		}
+	}
+}
+
+// TestTreeCheckpointClearsRanges tests that restoring a checkpoint into a
+// Tree that is building a multi-range proof discards the proof ranges.
+func TestTreeCheckpointClearsRanges(t *testing.T) {
+	tree := New(sha256.New())
+	for i := 0; i < 5; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	checkpoint, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := New(sha256.New())
+	if err := restored.SetRanges([]LeafRange{{1, 3}, {6, 7}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		restored.Push([]byte{byte(i)})
+	}
+	if err := restored.UnmarshalBinary(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if restored.proofRanges != nil || restored.rangeIndex != 0 || restored.rangeLeaf != 0 || restored.rangeHeight != 0 || restored.rangeProof != nil {
+		t.Fatal("restored tree kept its proof ranges")
+	}
+	// without proof ranges, the restored Tree can be checkpointed again
+	if _, err := restored.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 5; i < 9; i++ {
+		tree.Push([]byte{byte(i)})
+		restored.Push([]byte{byte(i)})
+	}
+	if !bytes.Equal(tree.Root(), restored.Root()) {
+		t.Fatal("restored tree has wrong root")
+	}
+}
+
+// TestCachedTreeCheckpoint tests that a CachedTree restored from a checkpoint
+// produces the same roots and proofs as the original CachedTree.
+func TestCachedTreeCheckpoint(t *testing.T) {
+	const cachedNodeHeight = 2
+	const numNodes = 11
+	nodes := make([][]byte, numNodes)
+	for i := range nodes {
+		nodes[i] = fastrand.Bytes(sha256.Size)
+	}
+	cachedProof := [][]byte{fastrand.Bytes(16), fastrand.Bytes(sha256.Size), fastrand.Bytes(sha256.Size)}
+	for split := 0; split <= numNodes; split++ {
+		tree := NewCachedTree(sha256.New(), cachedNodeHeight)
+		if err := tree.SetIndex(22); err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes[:split] {
+			tree.Push(node)
+		}
+		checkpoint, err := tree.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := NewCachedTree(sha256.New(), cachedNodeHeight)
+		if err := restored.UnmarshalBinary(checkpoint); err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes[split:] {
+			tree.Push(node)
+			restored.Push(node)
+		}
+		root1, proof1, index1, n1 := tree.Prove(cachedProof)
+		root2, proof2, index2, n2 := restored.Prove(cachedProof)
+		if !bytes.Equal(root1, root2) || !reflect.DeepEqual(proof1, proof2) || index1 != index2 || n1 != n2 {
+			t.Fatalf("restored tree has wrong proof (split %v)", split)
+		}
+	}
+
+	// a checkpoint should only be restored into a tree of the same kind
+	tree := NewCachedTree(sha256.New(), cachedNodeHeight)
+	tree.Push(nodes[0])
+	checkpoint, _ := tree.MarshalBinary()
+	if err := NewCachedTree(sha256.New(), cachedNodeHeight+1).UnmarshalBinary(checkpoint); err == nil {
+		t.Error("restored checkpoint into a tree with a different node height")
+	}
+	if err := New(sha256.New()).UnmarshalBinary(checkpoint[:len(checkpoint)-16]); err == nil {
+		t.Error("restored cached checkpoint into a regular tree")
+	}
+}
+
+// TestCheckpointInvalid tests that invalid checkpoints are rejected.
+func TestCheckpointInvalid(t *testing.T) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 11; i++ {
+		tree.Push(fastrand.Bytes(64))
+	}
+	checkpoint, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.Root()
+
+	// every truncation should be rejected
+	for i := 0; i < len(checkpoint); i++ {
+		if err := New(sha256.New()).UnmarshalBinary(checkpoint[:i]); err == nil {
+			t.Fatalf("restored checkpoint truncated to %v bytes", i)
+		}
+	}
+	if err := New(sha256.New()).UnmarshalBinary(append(checkpoint, 0)); err == nil {
+		t.Error("restored checkpoint with trailing bytes")
+	}
+
+	// header fields
+	corrupt := func(i int, b byte) []byte {
+		c := append([]byte(nil), checkpoint...)
+		c[i] = b
+		return c
+	}
+	if err := New(sha256.New()).UnmarshalBinary(corrupt(0, checkpointVersion+1)); err == nil {
+		t.Error("restored checkpoint with unknown version")
+	}
+	if err := New(sha256.New()).UnmarshalBinary(corrupt(2, 1)); err == nil {
+		t.Error("restored checkpoint of a cached tree into a regular tree")
+	}
+	if err := New(sha256.New()).UnmarshalBinary(corrupt(11, 10)); err == nil {
+		t.Error("restored checkpoint with wrong number of leaves")
+	}
+	if err := New(sha256.New()).UnmarshalBinary(corrupt(19, 9)); err == nil {
+		t.Error("restored checkpoint with a proof index in a different subtree")
+	}
+	blake, _ := blake2b.New512(nil)
+	if err := New(blake).UnmarshalBinary(checkpoint); err == nil {
+		t.Error("restored checkpoint into a tree with a different hash size")
+	}
+	if err := new(Tree).UnmarshalBinary(checkpoint); err == nil {
+		t.Error("restored checkpoint into a tree without a hash")
+	}
+
+	// a failed restore should leave the tree unchanged
+	if err := tree.UnmarshalBinary(checkpoint[:len(checkpoint)-1]); err == nil {
+		t.Fatal("restored truncated checkpoint")
+	} else if !bytes.Equal(tree.Root(), root) {
+		t.Fatal("failed restore modified the tree")
+	}
+
+	// random corruptions should never cause a panic
+	for i := 0; i < 1000; i++ {
+		c := append([]byte(nil), checkpoint...)
+		c[fastrand.Intn(len(c))] ^= byte(1 + fastrand.Intn(255))
+		_ = New(sha256.New()).UnmarshalBinary(c)
+	}
+}
@@ -0,0 +1,158 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// consistencyProofRanges returns the leaf ranges of the subtrees whose roots
+// make up a consistency proof between a tree of oldSize leaves and a tree of
+// newSize leaves. The ranges are returned in the order specified by RFC 6962,
+// section 2.1.2, i.e. the order in which the roots appear in the proof.
+func consistencyProofRanges(oldSize, newSize uint64) []LeafRange {
+	// This is a direct translation of the SUBPROOF algorithm in RFC 6962:
+	//
+	//   SUBPROOF(m, D[n], true)  = {}                          if m == n
+	//   SUBPROOF(m, D[n], false) = {MTH(D[n])}                 if m == n
+	//   SUBPROOF(m, D[n], b)     = SUBPROOF(m, D[0:k], b) : MTH(D[k:n])
+	//                                                          if m <= k
+	//   SUBPROOF(m, D[n], b)     = SUBPROOF(m - k, D[k:n], false) : MTH(D[0:k])
+	//                                                          if m > k
+	//
+	// where k is the largest power of two smaller than n. Since the tree
+	// shape of RFC 6962 is the same as the shape produced by Tree, every
+	// range is either a complete subtree of 2^i leaves, or the tail of the
+	// tree, ending at newSize.
+	var subproof func(m, start, end uint64, complete bool) []LeafRange
+	subproof = func(m, start, end uint64, complete bool) []LeafRange {
+		if m == end-start {
+			if complete {
+				return nil
+			}
+			return []LeafRange{{start, end}}
+		}
+		k := uint64(1) << uint(bits.Len64(end-start-1)-1)
+		if m <= k {
+			return append(subproof(m, start, start+k, complete), LeafRange{start + k, end})
+		}
+		return append(subproof(m-k, start+k, end, false), LeafRange{start, start + k})
+	}
+	return subproof(oldSize, 0, newSize, true)
+}
+
+// BuildConsistencyProof constructs a proof that the Merkle tree containing the
+// first oldSize leaves is a prefix of the Merkle tree containing newSize
+// leaves, using the provided SubtreeHasher. The proof hashes are ordered as
+// specified by RFC 6962. An error is returned if oldSize is zero or greater
+// than newSize.
+//
+// Unlike the rest of the tree, the final range of the proof need not be a
+// complete subtree. Its root is built from the subtree roots that sh produces
+// for it, which are joined using h. Requesting them separately, rather than
+// requesting the root of the whole range at once, ensures that an error is
+// returned if sh holds fewer than newSize leaves.
+func BuildConsistencyProof(sh SubtreeHasher, h hash.Hash, oldSize, newSize uint64) (proof [][]byte, err error) {
+	if oldSize == 0 || oldSize > newSize {
+		return nil, errors.New("invalid tree sizes")
+	}
+	ranges := consistencyProofRanges(oldSize, newSize)
+
+	// The SubtreeHasher can only produce subtree roots in sequential order,
+	// whereas RFC 6962 orders the proof from the bottom of the tree to the
+	// top. So we visit the ranges from left to right, and place each root at
+	// its position in the proof.
+	order := make([]int, len(ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return ranges[order[i]].Start < ranges[order[j]].Start
+	})
+
+	proof = make([][]byte, len(ranges))
+	var leafIndex uint64
+	for _, i := range order {
+		r := ranges[i]
+		// skip the leaves that are already covered by the old root
+		for leafIndex != r.Start {
+			subtreeSize := nextSubtreeSize(leafIndex, r.Start)
+			if err := sh.Skip(subtreeSize); err != nil {
+				return nil, err
+			}
+			leafIndex += uint64(subtreeSize)
+		}
+		// combine the subtree roots within the range into a single root
+		tree := New(h)
+		for leafIndex != r.End {
+			subtreeSize := nextSubtreeSize(leafIndex, r.End)
+			root, err := sh.NextSubtreeRoot(subtreeSize)
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, err
+			}
+			if err := tree.PushSubTree(bits.TrailingZeros64(uint64(subtreeSize)), root); err != nil {
+				return nil, err
+			}
+			leafIndex += uint64(subtreeSize)
+		}
+		proof[i] = tree.Root()
+	}
+	return proof, nil
+}
+
+// VerifyConsistencyProof verifies a proof produced by BuildConsistencyProof,
+// i.e. that the Merkle tree with root oldRoot and oldSize leaves is a prefix
+// of the Merkle tree with root newRoot and newSize leaves.
+func VerifyConsistencyProof(h hash.Hash, oldRoot, newRoot []byte, oldSize, newSize uint64, proof [][]byte) bool {
+	if oldSize == 0 || oldSize > newSize {
+		return false
+	}
+	if oldSize == newSize {
+		return len(proof) == 0 && bytes.Equal(oldRoot, newRoot)
+	}
+
+	// This follows the verification algorithm of RFC 9162, section 2.1.4.2.
+	// If the old tree is a complete subtree, its root is the first node on
+	// the path to the new root, and it is omitted from the proof.
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+
+	// fn and sn are the indices of the last leaf of the old and new tree.
+	// Rightmost nodes in the old tree that are also complete subtrees of the
+	// new tree are not part of the proof, so skip past them.
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	// fr is rebuilt into the old root, while sr is rebuilt into the new root.
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeSum(h, c, fr)
+			sr = nodeSum(h, c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeSum(h, sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, oldRoot) && bytes.Equal(sr, newRoot)
+}
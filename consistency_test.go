@@ -0,0 +1,184 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// rfcConsistencyProof is a recursive reference implementation of the
+// consistency proof algorithm described in RFC 6962, section 2.1.2.
+func rfcConsistencyProof(h hash.Hash, leafHashes [][]byte, m int) [][]byte {
+	mth := func(hashes [][]byte) []byte {
+		root, _ := NewCachedSubtreeHasher(hashes, h).NextSubtreeRoot(len(hashes))
+		return root
+	}
+	var subproof func(m int, d [][]byte, b bool) [][]byte
+	subproof = func(m int, d [][]byte, b bool) [][]byte {
+		n := len(d)
+		if m == n {
+			if b {
+				return nil
+			}
+			return [][]byte{mth(d)}
+		}
+		k := 1
+		for k*2 < n {
+			k *= 2
+		}
+		if m <= k {
+			return append(subproof(m, d[:k], b), mth(d[k:]))
+		}
+		return append(subproof(m-k, d[k:], false), mth(d[:k]))
+	}
+	return subproof(m, leafHashes, true)
+}
+
+// TestConsistencyProofVectors tests BuildConsistencyProof and
+// VerifyConsistencyProof against the test vectors used by the Certificate
+// Transparency reference implementation.
+func TestConsistencyProofVectors(t *testing.T) {
+	leaves := []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+	var leafHashes [][]byte
+	for _, l := range leaves {
+		data, _ := hex.DecodeString(l)
+		leafHashes = append(leafHashes, leafSum(sha256.New(), data))
+	}
+	root := func(n int) []byte {
+		root, _ := NewCachedSubtreeHasher(leafHashes[:n], sha256.New()).NextSubtreeRoot(n)
+		return root
+	}
+	if hex.EncodeToString(root(8)) != "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328" {
+		t.Fatal("wrong root for the reference tree")
+	}
+
+	tests := []struct {
+		oldSize, newSize uint64
+		proof            []string
+	}{
+		{1, 1, nil},
+		{1, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, 8, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 5, []string{
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+	for _, test := range tests {
+		proof, err := BuildConsistencyProof(NewCachedSubtreeHasher(leafHashes, sha256.New()), sha256.New(), test.oldSize, test.newSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proof) != len(test.proof) {
+			t.Errorf("wrong proof length for %v -> %v: expected %v, got %v", test.oldSize, test.newSize, len(test.proof), len(proof))
+			continue
+		}
+		for i := range proof {
+			if hex.EncodeToString(proof[i]) != test.proof[i] {
+				t.Errorf("wrong proof hash %v for %v -> %v", i, test.oldSize, test.newSize)
+			}
+		}
+		if !VerifyConsistencyProof(sha256.New(), root(int(test.oldSize)), root(int(test.newSize)), test.oldSize, test.newSize, proof) {
+			t.Errorf("failed to verify known proof for %v -> %v", test.oldSize, test.newSize)
+		}
+	}
+}
+
+// TestBuildVerifyConsistencyProof tests BuildConsistencyProof and
+// VerifyConsistencyProof for all pairs of small trees.
+func TestBuildVerifyConsistencyProof(t *testing.T) {
+	const leafSize = 16
+	const numLeaves = 33
+	h := sha256.New()
+	leafData := fastrand.Bytes(leafSize * numLeaves)
+	leafHashes := make([][]byte, numLeaves)
+	for i := range leafHashes {
+		leafHashes[i] = leafSum(h, leafData[i*leafSize:][:leafSize])
+	}
+	roots := make([][]byte, numLeaves+1)
+	for n := 1; n <= numLeaves; n++ {
+		roots[n] = bytesRoot(leafData[:n*leafSize], h, leafSize)
+	}
+
+	for n := uint64(1); n <= numLeaves; n++ {
+		for m := uint64(1); m <= n; m++ {
+			// alternate between leaf data and leaf hashes
+			var sh SubtreeHasher = NewCachedSubtreeHasher(leafHashes, h)
+			if (m+n)%2 == 0 {
+				sh = NewReaderSubtreeHasher(bytes.NewReader(leafData), leafSize, h)
+			}
+			proof, err := BuildConsistencyProof(sh, h, m, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp := rfcConsistencyProof(h, leafHashes[:n], int(m))
+			if len(proof) != len(exp) {
+				t.Fatalf("%v -> %v: expected proof of length %v, got %v", m, n, len(exp), len(proof))
+			}
+			for i := range proof {
+				if !bytes.Equal(proof[i], exp[i]) {
+					t.Fatalf("%v -> %v: proof does not match reference implementation", m, n)
+				}
+			}
+			if !VerifyConsistencyProof(h, roots[m], roots[n], m, n, proof) {
+				t.Fatalf("%v -> %v: failed to verify valid proof", m, n)
+			}
+
+			// the proof should not verify for other sizes or roots
+			if m > 1 && VerifyConsistencyProof(h, roots[m-1], roots[n], m-1, n, proof) {
+				t.Fatalf("%v -> %v: verified proof with wrong old size", m, n)
+			}
+			if n < numLeaves && VerifyConsistencyProof(h, roots[m], roots[n+1], m, n+1, proof) {
+				t.Fatalf("%v -> %v: verified proof with wrong new size", m, n)
+			}
+			if len(proof) > 0 {
+				bad := append([][]byte(nil), proof...)
+				i := fastrand.Intn(len(bad))
+				bad[i] = append([]byte(nil), bad[i]...)
+				bad[i][fastrand.Intn(len(bad[i]))]++
+				if VerifyConsistencyProof(h, roots[m], roots[n], m, n, bad) {
+					t.Fatalf("%v -> %v: verified corrupted proof", m, n)
+				}
+				if VerifyConsistencyProof(h, roots[m], roots[n], m, n, proof[:len(proof)-1]) {
+					t.Fatalf("%v -> %v: verified truncated proof", m, n)
+				}
+				if VerifyConsistencyProof(h, roots[m], roots[n], m, n, append(proof, proof[0])) {
+					t.Fatalf("%v -> %v: verified extended proof", m, n)
+				}
+			}
+		}
+	}
+
+	// building a proof from a SubtreeHasher that is too short should fail
+	sh := NewCachedSubtreeHasher(leafHashes[:10], h)
+	if _, err := BuildConsistencyProof(sh, h, 4, 11); err == nil {
+		t.Error("expected error when building proof past the end of the tree")
+	}
+
+	// nonsense sizes should be rejected
+	sh = NewCachedSubtreeHasher(leafHashes, h)
+	if _, err := BuildConsistencyProof(sh, h, 0, 1); err == nil {
+		t.Error("expected error when building proof from an empty tree")
+	}
+	if _, err := BuildConsistencyProof(sh, h, 2, 1); err == nil {
+		t.Error("expected error when building proof for a shrinking tree")
+	}
+	if VerifyConsistencyProof(h, roots[1], roots[1], 0, 1, nil) {
+		t.Error("verified proof from an empty tree")
+	}
+	if VerifyConsistencyProof(h, roots[2], roots[1], 2, 1, nil) {
+		t.Error("verified proof for a shrinking tree")
+	}
+}
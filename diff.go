@@ -2,6 +2,7 @@ package merkletree
 
 import (
 	"bytes"
+	"errors"
 	"hash"
 	"io"
 	"math/bits"
@@ -49,6 +50,16 @@ func BuildDiffProof(ranges []LeafRange, h SubtreeHasher, numLeaves uint64) (proo
 	return proof, err
 }
 
+// BuildDiffProofChecked is the same as BuildDiffProof, except that it returns
+// ErrInvalidRange, ErrUnsortedRanges, or ErrRangeOutOfBounds instead of
+// panicking if the ranges are invalid.
+func BuildDiffProofChecked(ranges []LeafRange, h SubtreeHasher, numLeaves uint64) ([][]byte, error) {
+	if err := checkRangesWithin(ranges, numLeaves); err != nil {
+		return nil, err
+	}
+	return BuildDiffProof(ranges, h, numLeaves)
+}
+
 // CompressLeafHashes takes the ranges of modified leaves as an input together
 // with a SubtreeHasher which can produce all modified leaf hashes to compress
 // the leaf hashes into subtrees where possible. These compressed leaf hashes
@@ -71,6 +82,16 @@ func CompressLeafHashes(ranges []LeafRange, h SubtreeHasher) (compressed [][]byt
 	return
 }
 
+// CompressLeafHashesChecked is the same as CompressLeafHashes, except that it
+// returns ErrInvalidRange or ErrUnsortedRanges instead of panicking if the
+// ranges are invalid.
+func CompressLeafHashesChecked(ranges []LeafRange, h SubtreeHasher) ([][]byte, error) {
+	if err := checkRangeSet(ranges); err != nil {
+		return nil, err
+	}
+	return CompressLeafHashes(ranges, h)
+}
+
 // VerifyDiffProof verifies a proof produced by BuildDiffProof using subtree
 // hashes produced by sh, which must contain the concatenation of the subtree
 // hashes within the proof ranges.
@@ -78,7 +99,190 @@ func VerifyDiffProof(rangeHashes [][]byte, numLeaves uint64, h hash.Hash, ranges
 	if !validRangeSet(ranges) {
 		panic("VerifyDiffProof: illegal set of proof ranges")
 	}
+	proofRoot, err := diffProofRoot(rangeHashes, numLeaves, h, ranges, proof)
+	return bytes.Equal(proofRoot, root), err
+}
+
+// VerifyDiffProofChecked is the same as VerifyDiffProof, except that it
+// returns ErrInvalidRange, ErrUnsortedRanges, or ErrRangeOutOfBounds instead
+// of panicking if the ranges are invalid. Since the number of leaves is known,
+// it also returns ErrProofTooShort or ErrProofTooLong if proof or rangeHashes
+// do not contain exactly the number of hashes that the tree requires.
+func VerifyDiffProofChecked(rangeHashes [][]byte, numLeaves uint64, h hash.Hash, ranges []LeafRange, proof [][]byte, root []byte) (bool, error) {
+	if err := checkRangesWithin(ranges, numLeaves); err != nil {
+		return false, err
+	}
+	proofLen, rangeHashesLen := diffProofSize(ranges, numLeaves)
+	if len(proof) < proofLen || len(rangeHashes) < rangeHashesLen {
+		return false, ErrProofTooShort
+	} else if len(proof) > proofLen || len(rangeHashes) > rangeHashesLen {
+		return false, ErrProofTooLong
+	}
+	return VerifyDiffProof(rangeHashes, numLeaves, h, ranges, proof, root)
+}
+
+// diffProofSize returns the number of proof hashes and the number of range
+// hashes that a diff proof for the specified ranges of a tree containing
+// numLeaves leaves consists of.
+func diffProofSize(ranges []LeafRange, numLeaves uint64) (proofLen, rangeHashesLen int) {
+	var leafIndex uint64
+	countUntil := func(end uint64) (n int) {
+		for ; leafIndex != end; n++ {
+			leafIndex += uint64(nextSubtreeSize(leafIndex, end))
+		}
+		return n
+	}
+	for _, r := range ranges {
+		proofLen += countUntil(r.Start)
+		rangeHashesLen += countUntil(r.End)
+	}
+	proofLen += countUntil(numLeaves)
+	return proofLen, rangeHashesLen
+}
+
+// UpdateRootWithDiffProof verifies a proof produced by BuildDiffProof in the
+// same way as VerifyDiffProof, using the subtree hashes of the proof ranges
+// before the modification. If the proof is valid, it returns the Merkle root
+// of the tree after the subtree hashes within the proof ranges have been
+// replaced by newRangeHashes. Both sets of hashes must be compressed with
+// CompressLeafHashes using the same ranges. If the ranges are invalid,
+// ErrInvalidRange, ErrUnsortedRanges, or ErrRangeOutOfBounds is returned. If
+// proof or either set of range hashes does not contain exactly the number of
+// hashes that the tree requires, ErrProofTooShort or ErrProofTooLong is
+// returned.
+func UpdateRootWithDiffProof(oldRangeHashes, newRangeHashes [][]byte, numLeaves uint64, h hash.Hash, ranges []LeafRange, proof [][]byte, oldRoot []byte) ([]byte, error) {
+	if err := checkRangesWithin(ranges, numLeaves); err != nil {
+		return nil, err
+	}
+	proofLen, rangeHashesLen := diffProofSize(ranges, numLeaves)
+	if len(proof) < proofLen || len(oldRangeHashes) < rangeHashesLen || len(newRangeHashes) < rangeHashesLen {
+		return nil, ErrProofTooShort
+	} else if len(proof) > proofLen || len(oldRangeHashes) > rangeHashesLen || len(newRangeHashes) > rangeHashesLen {
+		return nil, ErrProofTooLong
+	}
+	proofRoot, err := diffProofRoot(oldRangeHashes, numLeaves, h, ranges, proof)
+	if err != nil {
+		return nil, err
+	} else if !bytes.Equal(proofRoot, oldRoot) {
+		return nil, errors.New("diff proof does not match the old root")
+	}
+	return diffProofRoot(newRangeHashes, numLeaves, h, ranges, proof)
+}
+
+// BuildResizeDiffProof constructs a Merkle diff for a modification that may
+// also change the number of leaves in the tree, using the provided
+// SubtreeHasher, which must produce the subtree roots of the old tree. The
+// ranges are the ranges of modified leaves. They must be sorted and
+// non-overlapping, and must lie within the leaves that are kept, i.e. the
+// first min(oldNumLeaves, newNumLeaves) leaves. Leaves appended past
+// oldNumLeaves and leaves cut off past newNumLeaves do not need to be
+// included in the ranges. If the ranges are invalid, ErrInvalidRange,
+// ErrUnsortedRanges, or ErrRangeOutOfBounds is returned.
+//
+// If the number of leaves does not change, the proof is identical to the
+// proof produced by BuildDiffProof.
+func BuildResizeDiffProof(ranges []LeafRange, h SubtreeHasher, oldNumLeaves, newNumLeaves uint64) ([][]byte, error) {
+	numKept := oldNumLeaves
+	if newNumLeaves < numKept {
+		numKept = newNumLeaves
+	}
+	if err := checkRangesWithin(ranges, numKept); err != nil {
+		return nil, err
+	}
+	// The proof begins with a regular diff proof for the kept leaves. It is
+	// followed by the roots of the leaves that are cut off, which are needed
+	// to verify the old root.
+	proof, err := BuildDiffProof(ranges, h, numKept)
+	if err != nil {
+		return nil, err
+	}
+	for leafIndex := numKept; leafIndex != oldNumLeaves; {
+		subtreeSize := nextSubtreeSize(leafIndex, oldNumLeaves)
+		root, err := h.NextSubtreeRoot(subtreeSize)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		proof = append(proof, root)
+		leafIndex += uint64(subtreeSize)
+	}
+	return proof, nil
+}
+
+// VerifyResizeDiffProof verifies a proof produced by BuildResizeDiffProof
+// against the old root, and returns the Merkle root of the tree after the
+// modification. oldRangeHashes must contain the subtree hashes within the
+// proof ranges before the modification. newRangeHashes must contain the
+// subtree hashes within the proof ranges after the modification, followed by
+// the subtree hashes of the appended leaves [oldNumLeaves, newNumLeaves), if
+// any; both can be produced with CompressLeafHashes. If the ranges are
+// invalid, ErrInvalidRange, ErrUnsortedRanges, or ErrRangeOutOfBounds is
+// returned. If proof or either set of range hashes does not contain exactly
+// the number of hashes that the trees require, ErrProofTooShort or
+// ErrProofTooLong is returned.
+func VerifyResizeDiffProof(oldRangeHashes, newRangeHashes [][]byte, oldNumLeaves, newNumLeaves uint64, h hash.Hash, ranges []LeafRange, proof [][]byte, oldRoot []byte) ([]byte, error) {
+	numKept := oldNumLeaves
+	if newNumLeaves < numKept {
+		numKept = newNumLeaves
+	}
+	if err := checkRangesWithin(ranges, numKept); err != nil {
+		return nil, err
+	}
+	// The appended leaves form one more range of the new tree.
+	newRanges := ranges
+	if newNumLeaves > oldNumLeaves {
+		newRanges = append(ranges[:len(ranges):len(ranges)], LeafRange{oldNumLeaves, newNumLeaves})
+	}
+	proofLen, oldHashesLen := diffProofSize(ranges, numKept)
+	_, truncatedLen := diffProofSize([]LeafRange{{numKept, oldNumLeaves}}, oldNumLeaves)
+	_, newHashesLen := diffProofSize(newRanges, newNumLeaves)
+	proofLen += truncatedLen
+	if len(proof) < proofLen || len(oldRangeHashes) < oldHashesLen || len(newRangeHashes) < newHashesLen {
+		return nil, ErrProofTooShort
+	} else if len(proof) > proofLen || len(oldRangeHashes) > oldHashesLen || len(newRangeHashes) > newHashesLen {
+		return nil, ErrProofTooLong
+	}
+
+	// Compute the old root from the proof hashes of the kept leaves, the old
+	// range hashes, and the roots of the leaves that are cut off.
 	tree := New(h)
+	truncated, err := pushDiffProof(tree, oldRangeHashes, numKept, ranges, proof)
+	if err != nil {
+		return nil, err
+	}
+	keptProof := proof[:len(proof)-len(truncated)]
+	for leafIndex := numKept; leafIndex != oldNumLeaves; {
+		subtreeSize := nextSubtreeSize(leafIndex, oldNumLeaves)
+		if err := tree.PushSubTree(bits.TrailingZeros64(uint64(subtreeSize)), truncated[0]); err != nil {
+			return nil, err
+		}
+		truncated = truncated[1:]
+		leafIndex += uint64(subtreeSize)
+	}
+	if !bytes.Equal(tree.Root(), oldRoot) {
+		return nil, errors.New("diff proof does not match the old root")
+	}
+
+	// Compute the new root from the same proof hashes of the kept leaves and
+	// the new range hashes.
+	return diffProofRoot(newRangeHashes, newNumLeaves, h, newRanges, keptProof)
+}
+
+// diffProofRoot returns the Merkle root of the tree described by a proof
+// produced by BuildDiffProof together with the subtree hashes within the
+// proof ranges.
+func diffProofRoot(rangeHashes [][]byte, numLeaves uint64, h hash.Hash, ranges []LeafRange, proof [][]byte) ([]byte, error) {
+	tree := New(h)
+	_, err := pushDiffProof(tree, rangeHashes, numLeaves, ranges, proof)
+	return tree.Root(), err
+}
+
+// pushDiffProof pushes the subtree hashes of a proof produced by
+// BuildDiffProof, together with the subtree hashes within the proof ranges,
+// into an empty tree. It returns the proof hashes that were not needed to
+// reach numLeaves.
+func pushDiffProof(tree *Tree, rangeHashes [][]byte, numLeaves uint64, ranges []LeafRange, proof [][]byte) ([][]byte, error) {
 	var leafIndex uint64
 	consumeUntil := func(end uint64, hashes *[][]byte) error {
 		for leafIndex != end && len(*hashes) > 0 {
@@ -94,12 +298,12 @@ func VerifyDiffProof(rangeHashes [][]byte, numLeaves uint64, h hash.Hash, ranges
 	}
 	for _, r := range ranges {
 		if err := consumeUntil(r.Start, &proof); err != nil {
-			return false, err
+			return nil, err
 		}
 		if err := consumeUntil(r.End, &rangeHashes); err != nil {
-			return false, err
+			return nil, err
 		}
 	}
 	err := consumeUntil(numLeaves, &proof)
-	return bytes.Equal(tree.Root(), root), err
+	return proof, err
 }
@@ -0,0 +1,211 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// countingReaderAt counts the calls to ReadAt of an io.ReaderAt, and the
+// number of bytes read.
+type countingReaderAt struct {
+	r     io.ReaderAt
+	reads int
+	bytes int64
+}
+
+// ReadAt implements io.ReaderAt.
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	n, err := c.r.ReadAt(p, off)
+	c.bytes += int64(n)
+	return n, err
+}
+
+// writerAtBuffer is an in-memory io.WriterAt.
+type writerAtBuffer struct {
+	b []byte
+}
+
+// WriteAt implements io.WriterAt.
+func (w *writerAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(w.b) {
+		w.b = append(w.b, make([]byte, end-len(w.b))...)
+	}
+	return copy(w.b[off:], p), nil
+}
+
+// Bytes returns the contents of the buffer.
+func (w *writerAtBuffer) Bytes() []byte {
+	return w.b
+}
+
+// Len returns the length of the buffer.
+func (w *writerAtBuffer) Len() int {
+	return len(w.b)
+}
+
+// TestEncoding tests that combined and outboard encodings can be decoded,
+// both sequentially and after seeking.
+func TestEncoding(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	for numLeaves := 0; numLeaves < 40; numLeaves++ {
+		size := numLeaves * leafSize
+		if size > 0 {
+			size -= fastrand.Intn(leafSize)
+		}
+		data := fastrand.Bytes(size)
+		expRoot := bytesRoot(data, h, leafSize)
+
+		var combined, outboard writerAtBuffer
+		root, err := Encode(&combined, bytes.NewReader(data), h, leafSize)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(root, expRoot) {
+			t.Fatal("Encode returned the wrong root")
+		}
+		root, err = EncodeOutboard(&outboard, bytes.NewReader(data), h, leafSize)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(root, expRoot) {
+			t.Fatal("EncodeOutboard returned the wrong root")
+		}
+		numParents := 0
+		if numLeaves > 0 {
+			numParents = numLeaves - 1
+		}
+		if outboard.Len() != encodingHeaderSize+numParents*2*h.Size() {
+			t.Fatal("outboard encoding has the wrong size:", outboard.Len())
+		} else if combined.Len() != outboard.Len()+len(data) {
+			t.Fatal("combined encoding has the wrong size:", combined.Len())
+		}
+
+		for _, d := range []*Decoder{
+			NewDecoder(bytes.NewReader(combined.Bytes()), h, leafSize, root),
+			NewOutboardDecoder(bytes.NewReader(outboard.Bytes()), bytes.NewReader(data), h, leafSize, root),
+		} {
+			decoded, err := ioutil.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(decoded, data) {
+				t.Fatal("decoded data does not match")
+			}
+			if len(data) == 0 {
+				continue
+			}
+			for n := 0; n < 10; n++ {
+				pos := fastrand.Intn(len(data))
+				if _, err := d.Seek(int64(pos), io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, 1+fastrand.Intn(2*leafSize))
+				n, err := io.ReadFull(d, buf)
+				if err != nil && err != io.ErrUnexpectedEOF {
+					t.Fatal(err)
+				} else if !bytes.Equal(buf[:n], data[pos:][:n]) {
+					t.Fatal("data read after seeking does not match")
+				}
+			}
+			if end, err := d.Seek(0, io.SeekEnd); err != nil || end != int64(len(data)) {
+				t.Fatal("seeking to the end returned", end, err)
+			}
+		}
+	}
+
+	// the data is read from the current position of r
+	data := fastrand.Bytes(100)
+	r := bytes.NewReader(append(fastrand.Bytes(10), data...))
+	if _, err := r.Seek(10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	var enc writerAtBuffer
+	if root, err := Encode(&enc, r, h, leafSize); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(root, bytesRoot(data, h, leafSize)) {
+		t.Fatal("Encode did not start at the current position of r")
+	}
+}
+
+// TestEncodingReads tests that a Decoder reads each node of the encoding once
+// when reading in order, and only the nodes on the path to a leaf after
+// seeking.
+func TestEncodingReads(t *testing.T) {
+	const leafSize = 8
+	const numLeaves = 1000
+	h := sha256.New()
+	data := fastrand.Bytes(numLeaves * leafSize)
+	var enc writerAtBuffer
+	root, err := Encode(&enc, bytes.NewReader(data), h, leafSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &countingReaderAt{r: bytes.NewReader(enc.Bytes())}
+	if _, err := ioutil.ReadAll(NewDecoder(r, h, leafSize, root)); err != nil {
+		t.Fatal(err)
+	} else if r.reads != 1+(numLeaves-1)+numLeaves {
+		t.Fatalf("expected %v reads, got %v", 1+(numLeaves-1)+numLeaves, r.reads)
+	}
+
+	// the tree of 1000 leaves has a depth of 10
+	for i := 0; i < 10; i++ {
+		r := &countingReaderAt{r: bytes.NewReader(enc.Bytes())}
+		d := NewDecoder(r, h, leafSize, root)
+		pos := fastrand.Intn(len(data))
+		if _, err := d.Seek(int64(pos), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		var b [1]byte
+		if _, err := d.Read(b[:]); err != nil {
+			t.Fatal(err)
+		} else if b[0] != data[pos] {
+			t.Fatal("wrong data after seeking")
+		} else if r.reads > 1+10+1 {
+			t.Fatalf("reading one byte took %v reads", r.reads)
+		}
+	}
+}
+
+// TestEncodingCorrupt tests that a Decoder returns only authenticated data
+// from a corrupt encoding.
+func TestEncodingCorrupt(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	data := fastrand.Bytes(37*leafSize - 3)
+	var enc writerAtBuffer
+	root, err := Encode(&enc, bytes.NewReader(data), h, leafSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		corrupt := append([]byte(nil), enc.Bytes()...)
+		corrupt[encodingHeaderSize+fastrand.Intn(len(corrupt)-encodingHeaderSize)] ^= 1
+		decoded, err := ioutil.ReadAll(NewDecoder(bytes.NewReader(corrupt), h, leafSize, root))
+		if _, ok := err.(*CorruptSubtreeError); !ok {
+			t.Fatal("expected CorruptSubtreeError, got", err)
+		} else if !bytes.HasPrefix(data, decoded) {
+			t.Fatal("decoder returned corrupt data")
+		}
+	}
+
+	// a modified length must be detected
+	for _, length := range []uint64{uint64(len(data)) - 1, uint64(len(data)) + 1, uint64(len(data)) + leafSize} {
+		corrupt := append([]byte(nil), enc.Bytes()...)
+		binary.LittleEndian.PutUint64(corrupt, length)
+		if _, err := ioutil.ReadAll(NewDecoder(bytes.NewReader(corrupt), h, leafSize, root)); err == nil {
+			t.Fatal("expected error for a modified length", length)
+		}
+	}
+	corrupt := append([]byte(nil), enc.Bytes()...)
+	binary.LittleEndian.PutUint64(corrupt, 0)
+	if _, err := ioutil.ReadAll(NewDecoder(bytes.NewReader(corrupt), h, leafSize, root)); err != ErrInvalidProof {
+		t.Fatal("expected ErrInvalidProof for an empty encoding, got", err)
+	}
+}
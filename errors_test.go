@@ -0,0 +1,127 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestCheckRangeSet tests that checkRangeSet returns the correct error for
+// invalid sets of ranges.
+func TestCheckRangeSet(t *testing.T) {
+	tests := []struct {
+		ranges []LeafRange
+		err    error
+	}{
+		{nil, nil},
+		{[]LeafRange{{0, 1}}, nil},
+		{[]LeafRange{{0, 1}, {1, 3}, {7, 8}}, nil},
+		{[]LeafRange{{1, 1}}, ErrInvalidRange},
+		{[]LeafRange{{2, 1}}, ErrInvalidRange},
+		{[]LeafRange{{0, 1}, {3, 3}}, ErrInvalidRange},
+		{[]LeafRange{{3, 5}, {4, 6}}, ErrUnsortedRanges},
+		{[]LeafRange{{3, 5}, {0, 1}}, ErrUnsortedRanges},
+	}
+	for _, test := range tests {
+		if err := checkRangeSet(test.ranges); err != test.err {
+			t.Errorf("ranges %v: expected %v, got %v", test.ranges, test.err, err)
+		}
+	}
+	if err := checkRangesWithin([]LeafRange{{0, 1}, {3, 6}}, 5); err != ErrRangeOutOfBounds {
+		t.Error("expected ErrRangeOutOfBounds, got", err)
+	}
+	if err := checkRange(-1, 3); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+	if err := checkRange(3, 3); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+}
+
+// TestCheckedVariants tests that the Checked variants return errors for
+// invalid input, and otherwise behave like the functions they wrap.
+func TestCheckedVariants(t *testing.T) {
+	const leafSize = 16
+	const numLeaves = 13
+	h := sha256.New()
+	data := fastrand.Bytes(leafSize * numLeaves)
+	root := bytesRoot(data, h, leafSize)
+	leafHashes := make([][]byte, numLeaves)
+	for i := range leafHashes {
+		leafHashes[i] = leafSum(h, data[i*leafSize:][:leafSize])
+	}
+	badRanges := []LeafRange{{5, 7}, {2, 3}}
+
+	// range proofs
+	if _, err := BuildMultiRangeProofChecked(badRanges, NewCachedSubtreeHasher(leafHashes, h)); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+	if _, err := BuildRangeProofChecked(4, 2, NewCachedSubtreeHasher(leafHashes, h)); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+	if _, err := VerifyMultiRangeProofChecked(NewCachedLeafHasher(nil), h, badRanges, nil, root); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+	if _, err := VerifyRangeProofChecked(NewCachedLeafHasher(nil), h, -1, 2, nil, root); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+	proof, err := BuildRangeProofChecked(3, 6, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyRangeProofChecked(NewCachedLeafHasher(leafHashes[3:6]), h, 3, 6, proof, root); !ok || err != nil {
+		t.Fatal("valid range proof was rejected:", err)
+	}
+
+	// diff proofs
+	if _, err := BuildDiffProofChecked([]LeafRange{{3, 14}}, NewCachedSubtreeHasher(leafHashes, h), numLeaves); err != ErrRangeOutOfBounds {
+		t.Error("expected ErrRangeOutOfBounds, got", err)
+	}
+	if _, err := CompressLeafHashesChecked(badRanges, NewCachedSubtreeHasher(leafHashes, h)); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+	ranges := []LeafRange{{1, 5}, {8, 9}}
+	diffProof, err := BuildDiffProofChecked(ranges, NewCachedSubtreeHasher(leafHashes, h), numLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rangeHashes, err := CompressLeafHashesChecked(ranges, NewCachedSubtreeHasher(append(leafHashes[1:5:5], leafHashes[8]), h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyDiffProofChecked(rangeHashes, numLeaves, h, ranges, diffProof, root); !ok || err != nil {
+		t.Fatal("valid diff proof was rejected:", err)
+	}
+	if _, err := VerifyDiffProofChecked(rangeHashes, numLeaves, h, ranges, diffProof[1:], root); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort, got", err)
+	}
+	if _, err := VerifyDiffProofChecked(rangeHashes[1:], numLeaves, h, ranges, diffProof, root); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort, got", err)
+	}
+	if _, err := VerifyDiffProofChecked(rangeHashes, numLeaves, h, ranges, append(diffProof, root), root); err != ErrProofTooLong {
+		t.Error("expected ErrProofTooLong, got", err)
+	}
+	if _, err := VerifyDiffProofChecked(rangeHashes, numLeaves, h, badRanges, diffProof, root); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+
+	// single-leaf proofs
+	tree := New(h)
+	if _, _, _, _, err := tree.ProveChecked(); err != ErrIndexNotSet {
+		t.Error("expected ErrIndexNotSet, got", err)
+	}
+	if err := tree.SetIndex(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ReadAll(bytes.NewReader(data), leafSize); err != nil {
+		t.Fatal(err)
+	}
+	merkleRoot, proofSet, proofIndex, n, err := tree.ProveChecked()
+	if err != nil {
+		t.Fatal(err)
+	} else if !VerifyProof(h, merkleRoot, proofSet, proofIndex, n) {
+		t.Fatal("ProveChecked produced an invalid proof")
+	}
+}
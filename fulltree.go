@@ -0,0 +1,208 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"math/bits"
+)
+
+// A FullTree is a Merkle tree that keeps every node hash in a NodeStore.
+// Unlike Tree, which can only prove the leaf chosen with SetIndex before the
+// first call to Push, a FullTree can prove any leaf or set of leaf ranges once
+// it has been built, without hashing the data again. Proofs only need
+// O(log(n)) node lookups per range, which makes a FullTree backed by an
+// OutboardNodeStore suitable for serving proofs for very large files.
+//
+// The shape of the tree is the same as the shape of a Tree: orphans are
+// promoted rather than duplicated, so the roots and proofs of a FullTree are
+// identical to those of a Tree containing the same leaves.
+type FullTree struct {
+	// Only the roots of complete subtrees are stored. Incomplete subtrees on
+	// the right edge of the tree are computed on demand.
+	store     NodeStore
+	numLeaves uint64
+	hash      hash.Hash
+}
+
+// subtreeRoot returns the Merkle root of the leaves [start, end). The range is
+// decomposed into complete subtrees in the same way as BuildMultiRangeProof
+// decomposes ranges, and the subtrees are joined the same way Tree joins its
+// stack of subtrees. It follows that the result is only meaningful if [start,
+// end) is itself a subtree of the full tree, or the tail of the tree.
+func (ft *FullTree) subtreeRoot(start, end uint64) ([]byte, error) {
+	var roots [][]byte
+	for start != end {
+		subtreeSize := nextSubtreeSize(start, end)
+		height := bits.TrailingZeros64(uint64(subtreeSize))
+		root, err := ft.store.Node(height, start>>uint(height))
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+		start += uint64(subtreeSize)
+	}
+	root := roots[len(roots)-1]
+	for i := len(roots) - 2; i >= 0; i-- {
+		root = nodeSum(ft.hash, roots[i], root)
+	}
+	// Return a copy to prevent leaking a pointer to internal data.
+	return append(root[:0:0], root...), nil
+}
+
+// NewFullTree creates a new FullTree that keeps its nodes in memory. The
+// provided hash will be used for all hashing operations within the FullTree.
+func NewFullTree(h hash.Hash) *FullTree {
+	return NewStoredFullTree(h, NewMemoryNodeStore(), 0)
+}
+
+// NewStoredFullTree creates a FullTree that keeps its nodes in store. store
+// must already contain the nodes of a tree with numLeaves leaves; in
+// particular, numLeaves should be 0 if the store is empty. This allows an
+// OutboardNodeStore to be reopened without rehashing the data.
+func NewStoredFullTree(h hash.Hash, store NodeStore, numLeaves uint64) *FullTree {
+	return &FullTree{
+		store:     store,
+		numLeaves: numLeaves,
+		hash:      h,
+	}
+}
+
+// Push adds a leaf containing data to the FullTree.
+func (ft *FullTree) Push(data []byte) error {
+	return ft.PushLeafHash(leafSum(ft.hash, data))
+}
+
+// PushLeafHash adds a leaf with the specified leaf hash to the FullTree.
+func (ft *FullTree) PushLeafHash(leafHash []byte) error {
+	if err := ft.store.PutNode(0, ft.numLeaves, leafHash); err != nil {
+		return err
+	}
+	ft.numLeaves++
+
+	// Whenever a height contains an even number of nodes, the last two nodes
+	// form a new complete subtree one level up.
+	for height := 0; (ft.numLeaves>>uint(height))%2 == 0; height++ {
+		n := ft.numLeaves >> uint(height)
+		left, err := ft.store.Node(height, n-2)
+		if err != nil {
+			return err
+		}
+		right, err := ft.store.Node(height, n-1)
+		if err != nil {
+			return err
+		}
+		if err := ft.store.PutNode(height+1, n/2-1, nodeSum(ft.hash, left, right)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAll will read segments of size 'segmentSize' and push them into the
+// FullTree until EOF is reached, in the same way as (*Tree).ReadAll.
+func (ft *FullTree) ReadAll(r io.Reader, segmentSize int) error {
+	segment := make([]byte, segmentSize)
+	for {
+		n, readErr := io.ReadFull(r, segment)
+		if readErr == io.EOF {
+			// All data has been read.
+			break
+		} else if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		if err := ft.Push(segment[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NumLeaves returns the number of leaves in the FullTree.
+func (ft *FullTree) NumLeaves() uint64 {
+	return ft.numLeaves
+}
+
+// LeafHash returns the hash of the leaf at index i.
+func (ft *FullTree) LeafHash(i uint64) ([]byte, error) {
+	if i >= ft.numLeaves {
+		return nil, errors.New("leaf is not in the tree")
+	}
+	return ft.subtreeRoot(i, i+1)
+}
+
+// Root returns the Merkle root of the FullTree. It returns nil if the FullTree
+// is empty.
+func (ft *FullTree) Root() ([]byte, error) {
+	if ft.numLeaves == 0 {
+		return nil, nil
+	}
+	return ft.subtreeRoot(0, ft.numLeaves)
+}
+
+// Prove creates a proof that the leaf at index i is an element of the Merkle
+// tree. The proof set is ordered in the same way as the proof set produced by
+// (*Tree).Prove, except that it does not contain the leaf data. The proof can
+// be verified by prepending the leaf data to it:
+//
+//		proof, _ := ft.Prove(i)
+//		VerifyProof(h, root, append([][]byte{data}, proof...), i, ft.NumLeaves())
+func (ft *FullTree) Prove(i uint64) (proofSet [][]byte, err error) {
+	if i >= ft.numLeaves {
+		return nil, errors.New("cannot prove a leaf that is not in the tree")
+	}
+	proof, err := ft.ProveRange([]LeafRange{{i, i + 1}})
+	if err != nil {
+		return nil, err
+	}
+	return ConvertRangeProofToSingleProof(proof, int(i)), nil
+}
+
+// ProveRange constructs a proof for the specified leaf ranges. The ranges must
+// be sorted and non-overlapping. The proof is identical to the proof produced
+// by BuildMultiRangeProof, and can be verified with VerifyMultiRangeProof.
+func (ft *FullTree) ProveRange(ranges []LeafRange) ([][]byte, error) {
+	return BuildMultiRangeProof(ranges, ft.SubtreeHasher())
+}
+
+// SubtreeHasher returns a SubtreeHasher that produces the subtree roots of the
+// FullTree, starting at leaf 0, by looking up the stored nodes. It can be
+// passed to any of the functions that take a SubtreeHasher, such as
+// BuildDiffProof.
+func (ft *FullTree) SubtreeHasher() SubtreeHasher {
+	return &fullTreeSubtreeHasher{ft: ft}
+}
+
+// fullTreeSubtreeHasher implements SubtreeHasher by looking up the stored
+// subtree roots of a FullTree.
+type fullTreeSubtreeHasher struct {
+	ft    *FullTree
+	index uint64
+}
+
+// NextSubtreeRoot implements SubtreeHasher.
+func (fsh *fullTreeSubtreeHasher) NextSubtreeRoot(subtreeSize int) ([]byte, error) {
+	numLeaves := fsh.ft.numLeaves
+	if fsh.index >= numLeaves {
+		return nil, io.EOF
+	}
+	end := fsh.index + uint64(subtreeSize)
+	if end > numLeaves || end < fsh.index {
+		end = numLeaves
+	}
+	root, err := fsh.ft.subtreeRoot(fsh.index, end)
+	if err != nil {
+		return nil, err
+	}
+	fsh.index = end
+	return root, nil
+}
+
+// Skip implements SubtreeHasher.
+func (fsh *fullTreeSubtreeHasher) Skip(n int) error {
+	if uint64(n) > fsh.ft.numLeaves-fsh.index {
+		return io.ErrUnexpectedEOF
+	}
+	fsh.index += uint64(n)
+	return nil
+}
@@ -0,0 +1,137 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestFullTree tests that a FullTree produces the same roots and proofs as a
+// Tree containing the same leaves.
+func TestFullTree(t *testing.T) {
+	const leafSize = 32
+	const maxLeaves = 40
+	h := sha256.New()
+	data := fastrand.Bytes(leafSize * maxLeaves)
+	for n := uint64(0); n <= maxLeaves; n++ {
+		ft := NewFullTree(h)
+		if err := ft.ReadAll(bytes.NewReader(data[:n*leafSize]), leafSize); err != nil {
+			t.Fatal(err)
+		}
+		if ft.NumLeaves() != n {
+			t.Fatalf("expected %v leaves, got %v", n, ft.NumLeaves())
+		}
+		root := bytesRoot(data[:n*leafSize], h, leafSize)
+		if ftRoot, err := ft.Root(); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(ftRoot, root) {
+			t.Fatalf("FullTree with %v leaves has wrong root", n)
+		}
+
+		for i := uint64(0); i < n; i++ {
+			leaf := data[i*leafSize:][:leafSize]
+			if leafHash, err := ft.LeafHash(i); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(leafHash, leafSum(h, leaf)) {
+				t.Fatalf("wrong leaf hash for leaf %v of %v", i, n)
+			}
+
+			// compare against Tree.Prove
+			tree := New(h)
+			if err := tree.SetIndex(i); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.ReadAll(bytes.NewReader(data[:n*leafSize]), leafSize); err != nil {
+				t.Fatal(err)
+			}
+			_, expProof, _, _ := tree.Prove()
+			proof, err := ft.Prove(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proofSet := append([][]byte{leaf}, proof...)
+			if !reflect.DeepEqual(proofSet, expProof) {
+				t.Fatalf("proof for leaf %v of %v does not match Tree", i, n)
+			}
+			if !VerifyProof(h, root, proofSet, i, n) {
+				t.Fatalf("failed to verify proof for leaf %v of %v", i, n)
+			}
+		}
+		if _, err := ft.Prove(n); err == nil {
+			t.Fatal("expected error when proving leaf outside the tree")
+		}
+		if _, err := ft.LeafHash(n); err == nil {
+			t.Fatal("expected error when getting leaf hash outside the tree")
+		}
+	}
+}
+
+// TestFullTreeProveRange tests that a FullTree produces the same range proofs
+// as BuildMultiRangeProof.
+func TestFullTreeProveRange(t *testing.T) {
+	const leafSize = 16
+	const numLeaves = 9
+	h := sha256.New()
+	data := fastrand.Bytes(leafSize * numLeaves)
+	ft := NewFullTree(h)
+	if err := ft.ReadAll(bytes.NewReader(data), leafSize); err != nil {
+		t.Fatal(err)
+	}
+	root, err := ft.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// prove all possible range sets
+	var allRangeSets func(min, max uint64) [][]LeafRange
+	allRangeSets = func(min, max uint64) [][]LeafRange {
+		var all [][]LeafRange
+		for i := min; i < max; i++ {
+			for j := i + 1; j <= max; j++ {
+				all = append(all, []LeafRange{{i, j}})
+				for _, sub := range allRangeSets(j, max) {
+					withPrefix := append([]LeafRange{{i, j}}, sub...)
+					all = append(all, withPrefix)
+				}
+			}
+		}
+		return all
+	}
+	for _, ranges := range allRangeSets(0, numLeaves) {
+		proof, err := ft.ProveRange(ranges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expProof, err := BuildMultiRangeProof(ranges, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(proof, expProof) {
+			t.Fatalf("proof for %v does not match BuildMultiRangeProof", ranges)
+		}
+		var leafHashes [][]byte
+		for _, r := range ranges {
+			for i := r.Start; i < r.End; i++ {
+				leafHash, err := ft.LeafHash(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				leafHashes = append(leafHashes, leafHash)
+			}
+		}
+		ok, err := VerifyMultiRangeProof(NewCachedLeafHasher(leafHashes), h, ranges, proof, root)
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatalf("failed to verify proof for %v", ranges)
+		}
+	}
+
+	// a range past the end of the tree should fail
+	if _, err := ft.ProveRange([]LeafRange{{numLeaves - 1, numLeaves + 1}}); err == nil {
+		t.Fatal("expected error when proving range outside the tree")
+	}
+}
@@ -0,0 +1,78 @@
+package merkletree
+
+import (
+	"errors"
+	"sort"
+)
+
+// An Accumulator computes the Merkle root of a set of leaves that arrive in
+// any order, e.g. the segments of a file that is downloaded from several
+// hosts at once. Each run of consecutive leaves is held as a CompactRange, so
+// complete subtrees are folded into their roots as soon as all of their
+// leaves have arrived, and the memory used is proportional to the number of
+// gaps between the leaves rather than to the number of leaves.
+type Accumulator struct {
+	// ranges holds the runs of consecutive leaves, sorted by their first
+	// leaf. No two ranges are adjacent; they are merged instead.
+	ranges []*CompactRange
+}
+
+// NewAccumulator returns an empty Accumulator.
+func NewAccumulator() *Accumulator {
+	return &Accumulator{}
+}
+
+// Insert adds the leaf data at the specified index. Each index can only be
+// inserted once.
+func (a *Accumulator) Insert(index uint64, data []byte) error {
+	return a.InsertLeafHash(index, LeafSum(data))
+}
+
+// InsertLeafHash adds the hash of the leaf at the specified index, as
+// produced by LeafSum. Each index can only be inserted once.
+func (a *Accumulator) InsertLeafHash(index uint64, leafHash [32]byte) error {
+	// Find the first range that ends after the leaf.
+	i := sort.Search(len(a.ranges), func(i int) bool {
+		return a.ranges[i].End() > index
+	})
+	if i < len(a.ranges) && a.ranges[i].Begin() <= index {
+		return errors.New("leaf has already been inserted")
+	}
+	cr := NewCompactRange(index)
+	if err := cr.PushSubTree(0, leafHash); err != nil {
+		return err
+	}
+
+	// Merge the leaf into the range to its left, or insert it as a new
+	// range, and then merge the range to its right into it.
+	if i > 0 && a.ranges[i-1].End() == index {
+		i--
+		if err := a.ranges[i].Merge(cr); err != nil {
+			return err
+		}
+	} else {
+		a.ranges = append(a.ranges, nil)
+		copy(a.ranges[i+1:], a.ranges[i:])
+		a.ranges[i] = cr
+	}
+	if i+1 < len(a.ranges) && a.ranges[i+1].Begin() == a.ranges[i].End() {
+		if err := a.ranges[i].Merge(a.ranges[i+1]); err != nil {
+			return err
+		}
+		a.ranges = append(a.ranges[:i+1], a.ranges[i+2:]...)
+	}
+	return nil
+}
+
+// Root returns the Merkle root of the inserted leaves, which is the same as
+// the root of a Tree into which the leaves were pushed in order. It returns
+// an error if any leaf between 0 and the last inserted leaf is missing, and
+// the zero hash if no leaves have been inserted.
+func (a *Accumulator) Root() ([32]byte, error) {
+	if len(a.ranges) == 0 {
+		return [32]byte{}, nil
+	} else if len(a.ranges) > 1 || a.ranges[0].Begin() != 0 {
+		return [32]byte{}, errors.New("cannot compute the root while leaves are missing")
+	}
+	return a.ranges[0].Root()
+}
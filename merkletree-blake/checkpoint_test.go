@@ -0,0 +1,176 @@
+package merkletree
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestTreeCheckpoint tests that a Tree restored from a checkpoint produces the
+// same roots and proofs as the original Tree.
+func TestTreeCheckpoint(t *testing.T) {
+	const numLeaves = 37
+	leaves := make([][]byte, numLeaves)
+	for i := range leaves {
+		leaves[i] = fastrand.Bytes(1 + fastrand.Intn(64))
+	}
+	for split := 0; split <= numLeaves; split++ {
+		for _, proofIndex := range []int{-1, 0, 5, 16, numLeaves - 1} {
+			tree := New()
+			if proofIndex >= 0 {
+				if err := tree.SetIndex(uint64(proofIndex)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, leaf := range leaves[:split] {
+				tree.Push(leaf)
+			}
+			checkpoint, err := tree.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored := New()
+			if err := restored.UnmarshalBinary(checkpoint); err != nil {
+				t.Fatal(err)
+			}
+			for _, leaf := range leaves[split:] {
+				tree.Push(leaf)
+				restored.Push(leaf)
+			}
+			if tree.Root() != restored.Root() {
+				t.Fatalf("restored tree has wrong root (split %v, index %v)", split, proofIndex)
+			}
+			if proofIndex >= 0 {
+				root1, base1, proof1, index1, n1 := tree.Prove()
+				root2, base2, proof2, index2, n2 := restored.Prove()
+				if root1 != root2 || !bytes.Equal(base1, base2) || !reflect.DeepEqual(proof1, proof2) || index1 != index2 || n1 != n2 {
+					t.Fatalf("restored tree has wrong proof (split %v, index %v)", split, proofIndex)
+				}
+				if !VerifyProof(root2, proof2, index2, n2) {
+					t.Fatalf("restored tree produced invalid proof (split %v, index %v)", split, proofIndex)
+				}
+			}
+		}
+	}
+}
+
+// TestCachedTreeCheckpoint tests that a CachedTree restored from a checkpoint
+// produces the same roots and proofs as the original CachedTree.
+func TestCachedTreeCheckpoint(t *testing.T) {
+	const cachedNodeHeight = 2
+	const numNodes = 11
+	nodes := make([][32]byte, numNodes)
+	for i := range nodes {
+		fastrand.Read(nodes[i][:])
+	}
+	cachedProof := make([][32]byte, 3)
+	for i := range cachedProof {
+		fastrand.Read(cachedProof[i][:])
+	}
+	for split := 0; split <= numNodes; split++ {
+		tree := NewCachedTree(cachedNodeHeight)
+		if err := tree.SetIndex(22); err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes[:split] {
+			if err := tree.PushSubTree(0, node); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkpoint, err := tree.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := NewCachedTree(cachedNodeHeight)
+		if err := restored.UnmarshalBinary(checkpoint); err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes[split:] {
+			if err := tree.PushSubTree(0, node); err != nil {
+				t.Fatal(err)
+			}
+			if err := restored.PushSubTree(0, node); err != nil {
+				t.Fatal(err)
+			}
+		}
+		root1, proof1, index1, n1 := tree.Prove(cachedProof)
+		root2, proof2, index2, n2 := restored.Prove(cachedProof)
+		if root1 != root2 || !reflect.DeepEqual(proof1, proof2) || index1 != index2 || n1 != n2 {
+			t.Fatalf("restored tree has wrong proof (split %v)", split)
+		}
+	}
+
+	// a checkpoint should only be restored into a tree of the same kind
+	tree := NewCachedTree(cachedNodeHeight)
+	if err := tree.PushSubTree(0, nodes[0]); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, _ := tree.MarshalBinary()
+	if err := NewCachedTree(cachedNodeHeight + 1).UnmarshalBinary(checkpoint); err == nil {
+		t.Error("restored checkpoint into a tree with a different node height")
+	}
+	if err := New().UnmarshalBinary(checkpoint[:len(checkpoint)-16]); err == nil {
+		t.Error("restored cached checkpoint into a regular tree")
+	}
+}
+
+// TestCheckpointInvalid tests that invalid checkpoints are rejected.
+func TestCheckpointInvalid(t *testing.T) {
+	tree := New()
+	if err := tree.SetIndex(3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 11; i++ {
+		tree.Push(fastrand.Bytes(64))
+	}
+	checkpoint, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.Root()
+
+	// every truncation should be rejected
+	for i := 0; i < len(checkpoint); i++ {
+		if err := New().UnmarshalBinary(checkpoint[:i]); err == nil {
+			t.Fatalf("restored checkpoint truncated to %v bytes", i)
+		}
+	}
+	if err := New().UnmarshalBinary(append(checkpoint, 0)); err == nil {
+		t.Error("restored checkpoint with trailing bytes")
+	}
+
+	// header fields
+	corrupt := func(i int, b byte) []byte {
+		c := append([]byte(nil), checkpoint...)
+		c[i] = b
+		return c
+	}
+	if err := New().UnmarshalBinary(corrupt(0, checkpointVersion+1)); err == nil {
+		t.Error("restored checkpoint with unknown version")
+	}
+	if err := New().UnmarshalBinary(corrupt(2, 1)); err == nil {
+		t.Error("restored checkpoint of a cached tree into a regular tree")
+	}
+	if err := New().UnmarshalBinary(corrupt(3, 10)); err == nil {
+		t.Error("restored checkpoint with wrong number of leaves")
+	}
+	if err := New().UnmarshalBinary(corrupt(11, 9)); err == nil {
+		t.Error("restored checkpoint with a proof index in a different subtree")
+	}
+
+	// a failed restore should leave the tree unchanged
+	if err := tree.UnmarshalBinary(checkpoint[:len(checkpoint)-1]); err == nil {
+		t.Fatal("restored truncated checkpoint")
+	} else if tree.Root() != root {
+		t.Fatal("failed restore modified the tree")
+	}
+
+	// random corruptions should never cause a panic
+	for i := 0; i < 1000; i++ {
+		c := append([]byte(nil), checkpoint...)
+		c[fastrand.Intn(len(c))] ^= byte(1 + fastrand.Intn(255))
+		_ = New().UnmarshalBinary(c)
+	}
+}
@@ -0,0 +1,122 @@
+package merkletree
+
+import (
+	"errors"
+)
+
+// A CompactRange is a compact representation of the leaves [Begin, End) of a
+// Merkle tree. It holds the roots of the largest subtrees that the range can
+// be split into, which are the same subtrees that nextSubtreeSize produces
+// when walking from Begin to End. A Tree holds the compact range [0, n) in
+// its stack.
+//
+// Compact ranges of adjacent leaves can be merged without rehashing any
+// leaves, so that a large tree can be hashed by independent workers, each
+// building the compact range of its own slice of the leaves. Once a compact
+// range begins at leaf 0, its Merkle root can be computed.
+type CompactRange struct {
+	begin uint64
+	end   uint64
+
+	// stack holds the subtrees of the range, ordered from left to right.
+	// Their heights increase up to the largest subtree and then decrease.
+	stack []subTree
+}
+
+// NewCompactRange returns an empty CompactRange that begins at the specified
+// leaf.
+func NewCompactRange(begin uint64) *CompactRange {
+	return &CompactRange{
+		begin: begin,
+		end:   begin,
+	}
+}
+
+// Begin returns the index of the first leaf of the range.
+func (cr *CompactRange) Begin() uint64 {
+	return cr.begin
+}
+
+// End returns the index of the leaf following the last leaf of the range.
+func (cr *CompactRange) End() uint64 {
+	return cr.end
+}
+
+// Push appends a leaf to the end of the range.
+func (cr *CompactRange) Push(data []byte) {
+	// A subtree of height 0 can always be appended.
+	_ = cr.PushSubTree(0, LeafSum(data))
+}
+
+// PushSubTree appends the root of a subtree of the specified height to the
+// end of the range. As in a Merkle tree, a subtree of height h must begin at
+// a leaf index that is a multiple of 2^h.
+func (cr *CompactRange) PushSubTree(height int, sum [32]byte) error {
+	if height < 0 || height >= 64 {
+		return errors.New("invalid subtree height")
+	}
+	size := uint64(1) << uint(height)
+	if cr.end%size != 0 {
+		return errors.New("subtree is not aligned with the end of the range")
+	} else if cr.end+size < cr.end {
+		return errors.New("subtree extends past the last leaf of the tree")
+	}
+	cr.stack = append(cr.stack, subTree{
+		height: height,
+		sum:    sum,
+	})
+	cr.end += size
+
+	// Join the two rightmost subtrees for as long as they are siblings, i.e.
+	// as long as they have the same height and the left one is aligned with
+	// their parent.
+	for len(cr.stack) > 1 {
+		i := len(cr.stack) - 1
+		a, b := cr.stack[i-1], cr.stack[i]
+		parentSize := uint64(1) << uint(b.height+1)
+		if a.height != b.height || (cr.end-parentSize)%parentSize != 0 {
+			break
+		}
+		cr.stack[i-1] = joinSubTrees(a, b)
+		cr.stack = cr.stack[:i]
+	}
+	return nil
+}
+
+// Merge appends the leaves of other, which must begin where cr ends, to cr.
+// The subtrees of other are reused, so Merge takes O(log n) time. other is
+// not modified, and neither is cr if an error is returned.
+func (cr *CompactRange) Merge(other *CompactRange) error {
+	if other.begin != cr.end {
+		return errors.New("ranges are not adjacent")
+	}
+	// Push the subtrees onto a copy of cr, so that cr is left intact if one
+	// of them is rejected.
+	merged := *cr
+	merged.stack = append([]subTree(nil), cr.stack...)
+	for _, st := range other.stack {
+		if err := merged.PushSubTree(st.height, st.sum); err != nil {
+			return err
+		}
+	}
+	*cr = merged
+	return nil
+}
+
+// Root returns the Merkle root of the leaves [0, End). It returns an error
+// if the range does not begin at leaf 0, and the zero hash if the range is
+// empty.
+func (cr *CompactRange) Root() ([32]byte, error) {
+	if cr.begin != 0 {
+		return [32]byte{}, errors.New("cannot compute the root of a range that does not begin at leaf 0")
+	}
+	if len(cr.stack) == 0 {
+		return [32]byte{}, nil
+	}
+	// As in (*Tree).Root, join the subtrees from the smallest to the largest.
+	current := cr.stack[len(cr.stack)-1].sum
+	for i := len(cr.stack) - 2; i >= 0; i-- {
+		current = nodeSum(cr.stack[i].sum, current)
+	}
+	return current, nil
+}
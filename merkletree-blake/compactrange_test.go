@@ -0,0 +1,145 @@
+package merkletree
+
+import (
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestCompactRangeMerge tests that compact ranges built from random slices of
+// the leaves can be merged into the root of the full tree.
+func TestCompactRangeMerge(t *testing.T) {
+	const leafSize = 8
+	for numLeaves := uint64(0); numLeaves < 70; numLeaves++ {
+		data := fastrand.Bytes(int(numLeaves) * leafSize)
+		expRoot := bytesRoot(data, leafSize)
+		for n := 0; n < 5; n++ {
+			// split the leaves into random slices, and build a compact range
+			// for each of them
+			var ranges []*CompactRange
+			for begin := uint64(0); begin < numLeaves || len(ranges) == 0; {
+				end := begin + uint64(fastrand.Intn(10))
+				if end > numLeaves {
+					end = numLeaves
+				}
+				cr := NewCompactRange(begin)
+				for i := begin; i < end; i++ {
+					cr.Push(data[i*leafSize:][:leafSize])
+				}
+				if cr.Begin() != begin || cr.End() != end {
+					t.Fatalf("expected range [%v,%v), got [%v,%v)", begin, end, cr.Begin(), cr.End())
+				}
+				ranges = append(ranges, cr)
+				begin = end
+			}
+
+			// the subtrees of each range should be the subtrees produced by
+			// nextSubtreeSize
+			for _, cr := range ranges {
+				i := cr.Begin()
+				for _, st := range cr.stack {
+					size := uint64(nextSubtreeSize(i, cr.End()))
+					if size != 1<<uint(st.height) {
+						t.Fatalf("range [%v,%v) has a subtree of height %v at leaf %v", cr.Begin(), cr.End(), st.height, i)
+					} else if st.sum != bytesRoot(data[i*leafSize:(i+size)*leafSize], leafSize) {
+						t.Fatalf("range [%v,%v) has the wrong root for the subtree at leaf %v", cr.Begin(), cr.End(), i)
+					}
+					i += size
+				}
+				if i != cr.End() {
+					t.Fatalf("subtrees of range [%v,%v) end at leaf %v", cr.Begin(), cr.End(), i)
+				}
+			}
+
+			// merge the ranges in a random order
+			for len(ranges) > 1 {
+				i := fastrand.Intn(len(ranges) - 1)
+				if err := ranges[i].Merge(ranges[i+1]); err != nil {
+					t.Fatal(err)
+				}
+				ranges = append(ranges[:i+1], ranges[i+2:]...)
+			}
+			root, err := ranges[0].Root()
+			if err != nil {
+				t.Fatal(err)
+			} else if root != expRoot {
+				t.Fatalf("merged ranges of %v leaves have the wrong root", numLeaves)
+			}
+		}
+	}
+}
+
+// TestCompactRangeSubTrees tests that a CompactRange can be built from the
+// roots of subtrees.
+func TestCompactRangeSubTrees(t *testing.T) {
+	const leafSize = 8
+	data := fastrand.Bytes(23 * leafSize)
+	subtreeRoot := func(start, end uint64) [32]byte {
+		return bytesRoot(data[start*leafSize:end*leafSize], leafSize)
+	}
+
+	left := NewCompactRange(0)
+	if err := left.PushSubTree(2, subtreeRoot(0, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := left.PushSubTree(0, LeafSum(data[4*leafSize:5*leafSize])); err != nil {
+		t.Fatal(err)
+	}
+	right := NewCompactRange(5)
+	for _, st := range []struct {
+		height     int
+		start, end uint64
+	}{
+		{0, 5, 6},
+		{1, 6, 8},
+		{3, 8, 16},
+		{2, 16, 20},
+		{1, 20, 22},
+		{0, 22, 23},
+	} {
+		if err := right.PushSubTree(st.height, subtreeRoot(st.start, st.end)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := left.Merge(right); err != nil {
+		t.Fatal(err)
+	}
+	if root, err := left.Root(); err != nil {
+		t.Fatal(err)
+	} else if root != subtreeRoot(0, 23) {
+		t.Fatal("compact range built from subtrees has the wrong root")
+	}
+
+	// a subtree must be aligned with the end of the range
+	if err := NewCompactRange(2).PushSubTree(2, subtreeRoot(2, 6)); err == nil {
+		t.Error("expected error when pushing an unaligned subtree")
+	}
+	// ranges must be adjacent to be merged
+	if err := NewCompactRange(0).Merge(NewCompactRange(1)); err == nil {
+		t.Error("expected error when merging ranges that are not adjacent")
+	}
+	// a failed merge should leave the range unchanged
+	cr := NewCompactRange(0)
+	cr.Push(data[:leafSize])
+	before, _ := cr.Root()
+	bad := &CompactRange{
+		begin: 1,
+		end:   6,
+		stack: []subTree{
+			{height: 0, sum: subtreeRoot(1, 2)},
+			{height: 2, sum: subtreeRoot(2, 6)},
+		},
+	}
+	if err := cr.Merge(bad); err == nil {
+		t.Error("expected error when merging a range with an unaligned subtree")
+	} else if root, _ := cr.Root(); cr.End() != 1 || len(cr.stack) != 1 || !(root == before) {
+		t.Error("failed merge modified the range")
+	}
+	// only ranges that begin at leaf 0 have a root
+	if _, err := right.Root(); err == nil {
+		t.Error("expected error when computing the root of a range that does not begin at leaf 0")
+	}
+	if root, err := NewCompactRange(0).Root(); root != ([32]byte{}) || err != nil {
+		t.Error("expected zero root for an empty range")
+	}
+}
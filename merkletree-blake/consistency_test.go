@@ -0,0 +1,119 @@
+package merkletree
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// rfcConsistencyProof is a recursive reference implementation of the
+// consistency proof algorithm described in RFC 6962, section 2.1.2.
+func rfcConsistencyProof(leafHashes [][32]byte, m int) [][32]byte {
+	mth := func(hashes [][32]byte) [32]byte {
+		root, _ := NewCachedSubtreeHasher(hashes).NextSubtreeRoot(len(hashes))
+		return root
+	}
+	var subproof func(m int, d [][32]byte, b bool) [][32]byte
+	subproof = func(m int, d [][32]byte, b bool) [][32]byte {
+		n := len(d)
+		if m == n {
+			if b {
+				return nil
+			}
+			return [][32]byte{mth(d)}
+		}
+		k := 1
+		for k*2 < n {
+			k *= 2
+		}
+		if m <= k {
+			return append(subproof(m, d[:k], b), mth(d[k:]))
+		}
+		return append(subproof(m-k, d[k:], false), mth(d[:k]))
+	}
+	return subproof(m, leafHashes, true)
+}
+
+// TestBuildVerifyConsistencyProof tests BuildConsistencyProof and
+// VerifyConsistencyProof for all pairs of small trees.
+func TestBuildVerifyConsistencyProof(t *testing.T) {
+	const leafSize = 16
+	const numLeaves = 33
+	leafData := fastrand.Bytes(leafSize * numLeaves)
+	leafHashes := make([][32]byte, numLeaves)
+	for i := range leafHashes {
+		leafHashes[i] = LeafSum(leafData[i*leafSize:][:leafSize])
+	}
+	roots := make([][32]byte, numLeaves+1)
+	for n := 1; n <= numLeaves; n++ {
+		roots[n] = bytesRoot(leafData[:n*leafSize], leafSize)
+	}
+
+	for n := uint64(1); n <= numLeaves; n++ {
+		for m := uint64(1); m <= n; m++ {
+			// alternate between leaf data and leaf hashes
+			var sh SubtreeHasher = NewCachedSubtreeHasher(leafHashes)
+			if (m+n)%2 == 0 {
+				sh = NewReaderSubtreeHasher(bytes.NewReader(leafData), leafSize)
+			}
+			proof, err := BuildConsistencyProof(sh, m, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp := rfcConsistencyProof(leafHashes[:n], int(m))
+			if len(proof) != len(exp) {
+				t.Fatalf("%v -> %v: expected proof of length %v, got %v", m, n, len(exp), len(proof))
+			}
+			for i := range proof {
+				if proof[i] != exp[i] {
+					t.Fatalf("%v -> %v: proof does not match reference implementation", m, n)
+				}
+			}
+			if !VerifyConsistencyProof(roots[m], roots[n], m, n, proof) {
+				t.Fatalf("%v -> %v: failed to verify valid proof", m, n)
+			}
+
+			// the proof should not verify for other sizes or roots
+			if m > 1 && VerifyConsistencyProof(roots[m-1], roots[n], m-1, n, proof) {
+				t.Fatalf("%v -> %v: verified proof with wrong old size", m, n)
+			}
+			if n < numLeaves && VerifyConsistencyProof(roots[m], roots[n+1], m, n+1, proof) {
+				t.Fatalf("%v -> %v: verified proof with wrong new size", m, n)
+			}
+			if len(proof) > 0 {
+				bad := append([][32]byte(nil), proof...)
+				bad[fastrand.Intn(len(bad))][fastrand.Intn(32)]++
+				if VerifyConsistencyProof(roots[m], roots[n], m, n, bad) {
+					t.Fatalf("%v -> %v: verified corrupted proof", m, n)
+				}
+				if VerifyConsistencyProof(roots[m], roots[n], m, n, proof[:len(proof)-1]) {
+					t.Fatalf("%v -> %v: verified truncated proof", m, n)
+				}
+				if VerifyConsistencyProof(roots[m], roots[n], m, n, append(proof, proof[0])) {
+					t.Fatalf("%v -> %v: verified extended proof", m, n)
+				}
+			}
+		}
+	}
+
+	// building a proof from a SubtreeHasher that is too short should fail
+	if _, err := BuildConsistencyProof(NewCachedSubtreeHasher(leafHashes[:10]), 4, 11); err == nil {
+		t.Error("expected error when building proof past the end of the tree")
+	}
+
+	// nonsense sizes should be rejected
+	sh := NewCachedSubtreeHasher(leafHashes)
+	if _, err := BuildConsistencyProof(sh, 0, 1); err == nil {
+		t.Error("expected error when building proof from an empty tree")
+	}
+	if _, err := BuildConsistencyProof(sh, 2, 1); err == nil {
+		t.Error("expected error when building proof for a shrinking tree")
+	}
+	if VerifyConsistencyProof(roots[1], roots[1], 0, 1, nil) {
+		t.Error("verified proof from an empty tree")
+	}
+	if VerifyConsistencyProof(roots[2], roots[1], 2, 1, nil) {
+		t.Error("verified proof for a shrinking tree")
+	}
+}
@@ -0,0 +1,381 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// The encodings written by Encode and EncodeOutboard allow data to be
+// verified against its Merkle root while it is being read, and from any
+// position, without a separate proof. An encoding begins with an 8-byte
+// little-endian header holding the length of the data, followed by the
+// nodes of the Merkle tree in pre-order. Each parent node is stored as the
+// roots of its two children; each leaf is stored as its data. The root
+// itself is not stored, since the reader must already know it. The shape of
+// the tree is the shape produced by a Tree: the left child of a node always
+// holds the largest power of two leaves that is less than the number of
+// leaves of the node.
+//
+// An outboard encoding holds only the header and the parent nodes, and the
+// data is stored separately.
+
+// encodingHeaderSize is the size of the header of an encoding.
+const encodingHeaderSize = 8
+
+// leftSubtreeSize returns the number of leaves in the left child of a node
+// with n leaves, which must be at least 2.
+func leftSubtreeSize(n uint64) uint64 {
+	return 1 << uint(bits.Len64(n-1)-1)
+}
+
+// encodedSubtreeSize returns the size of the encoding of a subtree of n full
+// leaves, excluding the header.
+func encodedSubtreeSize(n uint64, hashSize, leafSize int, outboard bool) int64 {
+	size := int64(n-1) * 2 * int64(hashSize)
+	if !outboard {
+		size += int64(n) * int64(leafSize)
+	}
+	return size
+}
+
+// An encoder writes an encoding in a single pass over the data. The length
+// of the data, and therefore the size of the encoding of every subtree, is
+// known up front, so each node is written at the offset reserved for it: a
+// parent node is written once the roots of its children are known, after the
+// children themselves. Only the roots on the path to the current leaf are
+// held in memory.
+type encoder struct {
+	w        io.WriterAt
+	r        io.Reader
+	leafSize int
+	outboard bool
+
+	remaining uint64 // bytes of data left to read
+	leaf      []byte
+	parent    []byte
+}
+
+// encodeSubtree reads the data of the next subtree of n leaves, writes its
+// encoding at offset off, and returns its root.
+func (e *encoder) encodeSubtree(n uint64, off int64) ([32]byte, error) {
+	if n == 1 {
+		// the last leaf may be partial
+		size := e.remaining
+		if size > uint64(e.leafSize) {
+			size = uint64(e.leafSize)
+		}
+		leaf := e.leaf[:size]
+		if _, err := io.ReadFull(e.r, leaf); err == io.EOF {
+			return [32]byte{}, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return [32]byte{}, err
+		}
+		e.remaining -= size
+		if !e.outboard {
+			if _, err := e.w.WriteAt(leaf, off); err != nil {
+				return [32]byte{}, err
+			}
+		}
+		return LeafSum(leaf), nil
+	}
+
+	const hashSize = 32
+	mid := leftSubtreeSize(n)
+	left, err := e.encodeSubtree(mid, off+2*int64(hashSize))
+	if err != nil {
+		return [32]byte{}, err
+	}
+	rightOff := off + 2*int64(hashSize) + encodedSubtreeSize(mid, hashSize, e.leafSize, e.outboard)
+	right, err := e.encodeSubtree(n-mid, rightOff)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	e.parent = append(append(e.parent[:0], left[:]...), right[:]...)
+	if _, err := e.w.WriteAt(e.parent, off); err != nil {
+		return [32]byte{}, err
+	}
+	return nodeSum(left, right), nil
+}
+
+// encode writes the encoding of the data read from r, from its current
+// position to its end, to w, and returns the Merkle root of the data.
+func encode(w io.WriterAt, r io.ReadSeeker, leafSize int, outboard bool) ([32]byte, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return [32]byte{}, err
+	}
+	length := uint64(end - start)
+	var header [encodingHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[:], length)
+	if _, err := w.WriteAt(header[:], 0); err != nil {
+		return [32]byte{}, err
+	}
+	if length == 0 {
+		return [32]byte{}, nil
+	}
+
+	e := &encoder{
+		w:         w,
+		r:         r,
+		leafSize:  leafSize,
+		outboard:  outboard,
+		remaining: length,
+		leaf:      make([]byte, leafSize),
+	}
+	numLeaves := (length + uint64(leafSize) - 1) / uint64(leafSize)
+	return e.encodeSubtree(numLeaves, encodingHeaderSize)
+}
+
+// Encode writes the combined encoding of the data read from r, in which the
+// leaf data is interleaved with the parent nodes, to w. The data is read
+// once, from the current position of r to its end; w must allow the nodes to
+// be written out of order, since each parent node precedes its children but
+// can only be computed after them. Encode returns the Merkle root of the
+// data, which is the same as the root computed by ReaderRoot.
+func Encode(w io.WriterAt, r io.ReadSeeker, leafSize int) ([32]byte, error) {
+	return encode(w, r, leafSize, false)
+}
+
+// EncodeOutboard writes the outboard encoding of the data read from r, which
+// holds only the parent nodes of the tree, to w. As with Encode, the data is
+// read once, from the current position of r to its end. It returns the
+// Merkle root of the data, which is the same as the root computed by
+// ReaderRoot.
+func EncodeOutboard(w io.WriterAt, r io.ReadSeeker, leafSize int) ([32]byte, error) {
+	return encode(w, r, leafSize, true)
+}
+
+// A decoderNode is a node on the path from the root to the current leaf of a
+// Decoder. Its root has been authenticated, as have its children once they
+// have been read.
+type decoderNode struct {
+	start, end  uint64 // leaves
+	offset      int64  // offset of the node in the encoding
+	sum         [32]byte
+	left, right [32]byte
+	children    bool // whether left and right have been read
+}
+
+// A Decoder reads data from an encoding written by Encode or EncodeOutboard,
+// verifying each leaf against the Merkle root before returning any of its
+// data. A Decoder keeps the authenticated path from the root to the current
+// leaf, so reading the data in order reads each node of the encoding once,
+// and seeking to another position only reads the O(log n) nodes on the path
+// to it.
+//
+// The length of the data is read from the header of the encoding, and is
+// only authenticated once the last leaf has been verified; however, data
+// returned by Read is always authentic. If the encoding does not match the
+// root, Read returns a *CorruptSubtreeError identifying the leaves of the
+// first node that does not match.
+type Decoder struct {
+	tree     io.ReaderAt
+	data     io.ReaderAt // nil for a combined encoding
+	leafSize int
+	root     [32]byte
+
+	length    uint64
+	numLeaves uint64
+	header    bool
+	pos       int64
+
+	path     []decoderNode
+	leaf     []byte
+	leafNode uint64
+	err      error
+}
+
+// NewDecoder returns a Decoder that reads from the combined encoding
+// produced by Encode, and verifies it against the specified Merkle root.
+func NewDecoder(encoding io.ReaderAt, leafSize int, root [32]byte) *Decoder {
+	return &Decoder{
+		tree:     encoding,
+		leafSize: leafSize,
+		root:     root,
+	}
+}
+
+// NewOutboardDecoder returns a Decoder that reads from the outboard encoding
+// produced by EncodeOutboard and from the data it was produced from, and
+// verifies them against the specified Merkle root.
+func NewOutboardDecoder(outboard, data io.ReaderAt, leafSize int, root [32]byte) *Decoder {
+	d := NewDecoder(outboard, leafSize, root)
+	d.data = data
+	return d
+}
+
+// readHeader reads the length of the data from the header of the encoding.
+func (d *Decoder) readHeader() error {
+	if d.header {
+		return nil
+	}
+	var header [encodingHeaderSize]byte
+	if err := readAt(d.tree, header[:], 0); err != nil {
+		return err
+	}
+	d.length = binary.LittleEndian.Uint64(header[:])
+	if d.length > math.MaxInt64 {
+		return errors.New("invalid data length")
+	}
+	d.numLeaves = (d.length + uint64(d.leafSize) - 1) / uint64(d.leafSize)
+	// Only an empty tree has the zero root.
+	if (d.numLeaves == 0) != (d.root == [32]byte{}) {
+		return ErrInvalidProof
+	}
+	d.header = true
+	return nil
+}
+
+// readAt reads len(b) bytes from r at the specified offset, treating a
+// short read as io.ErrUnexpectedEOF.
+func readAt(r io.ReaderAt, b []byte, off int64) error {
+	n, err := r.ReadAt(b, off)
+	if n == len(b) {
+		return nil
+	} else if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readLeaf returns the authenticated data of the leaf at the specified
+// index, reading and verifying the nodes on the path to it that are not
+// already on the current path.
+func (d *Decoder) readLeaf(index uint64) ([]byte, error) {
+	if d.leaf != nil && d.leafNode == index {
+		return d.leaf, nil
+	}
+	const hashSize = 32
+	outboard := d.data != nil
+
+	// Walk up the current path until reaching a node that contains the
+	// leaf, then walk down to the leaf.
+	for len(d.path) > 0 {
+		n := d.path[len(d.path)-1]
+		if n.start <= index && index < n.end {
+			break
+		}
+		d.path = d.path[:len(d.path)-1]
+	}
+	if len(d.path) == 0 {
+		d.path = append(d.path, decoderNode{
+			start:  0,
+			end:    d.numLeaves,
+			offset: encodingHeaderSize,
+			sum:    d.root,
+		})
+	}
+	for {
+		n := &d.path[len(d.path)-1]
+		if n.end-n.start == 1 {
+			break
+		}
+		if !n.children {
+			children := make([]byte, 2*hashSize)
+			if err := readAt(d.tree, children, n.offset); err != nil {
+				return nil, err
+			}
+			copy(n.left[:], children[:hashSize])
+			copy(n.right[:], children[hashSize:])
+			if nodeSum(n.left, n.right) != n.sum {
+				return nil, &CorruptSubtreeError{Start: n.start, End: n.end}
+			}
+			n.children = true
+		}
+		mid := n.start + leftSubtreeSize(n.end-n.start)
+		child := decoderNode{
+			start:  n.start,
+			end:    mid,
+			offset: n.offset + int64(2*hashSize),
+			sum:    n.left,
+		}
+		if index >= mid {
+			child = decoderNode{
+				start:  mid,
+				end:    n.end,
+				offset: child.offset + encodedSubtreeSize(mid-n.start, hashSize, d.leafSize, outboard),
+				sum:    n.right,
+			}
+		}
+		d.path = append(d.path, child)
+	}
+
+	// Read and verify the leaf itself.
+	n := d.path[len(d.path)-1]
+	leafSize := uint64(d.leafSize)
+	if index == d.numLeaves-1 {
+		leafSize = d.length - index*uint64(d.leafSize)
+	}
+	leaf := make([]byte, leafSize)
+	var err error
+	if outboard {
+		err = readAt(d.data, leaf, int64(index)*int64(d.leafSize))
+	} else {
+		err = readAt(d.tree, leaf, n.offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if LeafSum(leaf) != n.sum {
+		return nil, &CorruptSubtreeError{Start: index, End: index + 1}
+	}
+	d.leaf, d.leafNode = leaf, index
+	return leaf, nil
+}
+
+// Read implements io.Reader.
+func (d *Decoder) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if err := d.readHeader(); err != nil {
+		d.err = err
+		return 0, err
+	}
+	if d.pos < 0 {
+		return 0, errors.New("negative position")
+	} else if uint64(d.pos) >= d.length {
+		return 0, io.EOF
+	}
+	index := uint64(d.pos) / uint64(d.leafSize)
+	leaf, err := d.readLeaf(index)
+	if err != nil {
+		d.err = err
+		return 0, err
+	}
+	n := copy(p, leaf[uint64(d.pos)-index*uint64(d.leafSize):])
+	d.pos += int64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker. Seeking relative to the end uses the length
+// from the header of the encoding.
+func (d *Decoder) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		if err := d.readHeader(); err != nil {
+			return 0, err
+		}
+		offset += int64(d.length)
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,83 @@
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidRange is returned when a proof range is empty or negative.
+	ErrInvalidRange = errors.New("invalid proof range")
+
+	// ErrUnsortedRanges is returned when a set of proof ranges is not sorted,
+	// or when two of its ranges overlap.
+	ErrUnsortedRanges = errors.New("proof ranges are not sorted and non-overlapping")
+
+	// ErrRangeOutOfBounds is returned when a proof range extends past the
+	// last leaf of the tree.
+	ErrRangeOutOfBounds = errors.New("proof range extends past the end of the tree")
+
+	// ErrIndexNotSet is returned when a proof is requested from a Tree on
+	// which SetIndex was not called.
+	ErrIndexNotSet = errors.New("SetIndex was not called on the tree")
+
+	// ErrProofTooShort is returned when a proof contains fewer hashes than
+	// the tree requires.
+	ErrProofTooShort = errors.New("proof is too short")
+
+	// ErrProofTooLong is returned when a proof contains more hashes than the
+	// tree requires.
+	ErrProofTooLong = errors.New("proof is too long")
+
+	// ErrInvalidProof is returned when a proof does not match the Merkle
+	// root it is verified against.
+	ErrInvalidProof = errors.New("proof does not match the Merkle root")
+)
+
+// A CorruptSubtreeError is returned by a VerifyingReader when the data of a
+// subtree does not match its authenticated root. Start and End are the
+// leaves [Start,End) of the subtree.
+type CorruptSubtreeError struct {
+	Start uint64
+	End   uint64
+}
+
+// Error implements the error interface.
+func (e *CorruptSubtreeError) Error() string {
+	return fmt.Sprintf("data of leaves [%v,%v) does not match the Merkle root", e.Start, e.End)
+}
+
+// checkRangeSet returns ErrInvalidRange if any of the ranges is empty, and
+// ErrUnsortedRanges if the ranges are not sorted and non-overlapping.
+func checkRangeSet(ranges []LeafRange) error {
+	for i, r := range ranges {
+		if r.Start >= r.End {
+			return ErrInvalidRange
+		}
+		if i > 0 && ranges[i-1].End > r.Start {
+			return ErrUnsortedRanges
+		}
+	}
+	return nil
+}
+
+// checkRange returns ErrInvalidRange if [proofStart, proofEnd) is not a valid
+// proof range. An empty range is valid.
+func checkRange(proofStart, proofEnd int) error {
+	if proofStart < 0 || proofStart > proofEnd {
+		return ErrInvalidRange
+	}
+	return nil
+}
+
+// checkRangesWithin returns the error of checkRangeSet, or
+// ErrRangeOutOfBounds if the ranges extend past numLeaves.
+func checkRangesWithin(ranges []LeafRange, numLeaves uint64) error {
+	if err := checkRangeSet(ranges); err != nil {
+		return err
+	}
+	if len(ranges) > 0 && ranges[len(ranges)-1].End > numLeaves {
+		return ErrRangeOutOfBounds
+	}
+	return nil
+}
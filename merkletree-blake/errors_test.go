@@ -0,0 +1,129 @@
+package merkletree
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestCheckRangeSet tests that checkRangeSet returns the correct error for
+// invalid sets of ranges.
+func TestCheckRangeSet(t *testing.T) {
+	tests := []struct {
+		ranges []LeafRange
+		err    error
+	}{
+		{nil, nil},
+		{[]LeafRange{{0, 1}}, nil},
+		{[]LeafRange{{0, 1}, {1, 3}, {7, 8}}, nil},
+		{[]LeafRange{{1, 1}}, ErrInvalidRange},
+		{[]LeafRange{{2, 1}}, ErrInvalidRange},
+		{[]LeafRange{{0, 1}, {3, 3}}, ErrInvalidRange},
+		{[]LeafRange{{3, 5}, {4, 6}}, ErrUnsortedRanges},
+		{[]LeafRange{{3, 5}, {0, 1}}, ErrUnsortedRanges},
+	}
+	for _, test := range tests {
+		if err := checkRangeSet(test.ranges); err != test.err {
+			t.Errorf("ranges %v: expected %v, got %v", test.ranges, test.err, err)
+		}
+	}
+	if err := checkRangesWithin([]LeafRange{{0, 1}, {3, 6}}, 5); err != ErrRangeOutOfBounds {
+		t.Error("expected ErrRangeOutOfBounds, got", err)
+	}
+	if err := checkRange(-1, 3); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+	if err := checkRange(4, 3); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+	// unlike a LeafRange, an empty proof range is valid
+	if err := checkRange(3, 3); err != nil {
+		t.Error("expected empty range to be valid, got", err)
+	}
+}
+
+// TestCheckedVariants tests that the Checked variants return errors for
+// invalid input, and otherwise behave like the functions they wrap.
+func TestCheckedVariants(t *testing.T) {
+	const leafSize = 16
+	const numLeaves = 13
+	data := fastrand.Bytes(leafSize * numLeaves)
+	root := bytesRoot(data, leafSize)
+	leafHashes := make([][32]byte, numLeaves)
+	for i := range leafHashes {
+		leafHashes[i] = LeafSum(data[i*leafSize:][:leafSize])
+	}
+	badRanges := []LeafRange{{5, 7}, {2, 3}}
+
+	// range proofs
+	if _, err := BuildMultiRangeProofChecked(badRanges, NewCachedSubtreeHasher(leafHashes)); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+	if _, err := BuildRangeProofChecked(4, 2, NewCachedSubtreeHasher(leafHashes)); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+	if _, err := VerifyMultiRangeProofChecked(NewCachedLeafHasher(nil), badRanges, nil, root); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+	if _, err := VerifyRangeProofChecked(NewCachedLeafHasher(nil), -1, 2, nil, root); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+	proof, err := BuildRangeProofChecked(3, 6, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyRangeProofChecked(NewCachedLeafHasher(leafHashes[3:6]), 3, 6, proof, root); !ok || err != nil {
+		t.Fatal("valid range proof was rejected:", err)
+	}
+
+	// diff proofs
+	if _, err := BuildDiffProofChecked([]LeafRange{{3, 14}}, NewCachedSubtreeHasher(leafHashes), numLeaves); err != ErrRangeOutOfBounds {
+		t.Error("expected ErrRangeOutOfBounds, got", err)
+	}
+	if _, err := CompressLeafHashesChecked(badRanges, NewCachedSubtreeHasher(leafHashes)); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+	ranges := []LeafRange{{1, 5}, {8, 9}}
+	diffProof, err := BuildDiffProofChecked(ranges, NewCachedSubtreeHasher(leafHashes), numLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rangeHashes, err := CompressLeafHashesChecked(ranges, NewCachedSubtreeHasher(append(leafHashes[1:5:5], leafHashes[8])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyDiffProofChecked(rangeHashes, numLeaves, ranges, diffProof, root); !ok || err != nil {
+		t.Fatal("valid diff proof was rejected:", err)
+	}
+	if _, err := VerifyDiffProofChecked(rangeHashes, numLeaves, ranges, diffProof[1:], root); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort, got", err)
+	}
+	if _, err := VerifyDiffProofChecked(rangeHashes[1:], numLeaves, ranges, diffProof, root); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort, got", err)
+	}
+	if _, err := VerifyDiffProofChecked(rangeHashes, numLeaves, ranges, append(diffProof, root), root); err != ErrProofTooLong {
+		t.Error("expected ErrProofTooLong, got", err)
+	}
+	if _, err := VerifyDiffProofChecked(rangeHashes, numLeaves, badRanges, diffProof, root); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+
+	// single-leaf proofs
+	tree := New()
+	if _, _, _, _, _, err := tree.ProveChecked(); err != ErrIndexNotSet {
+		t.Error("expected ErrIndexNotSet, got", err)
+	}
+	if err := tree.SetIndex(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ReadAll(bytes.NewReader(data), leafSize); err != nil {
+		t.Fatal(err)
+	}
+	merkleRoot, _, proofSet, proofIndex, n, err := tree.ProveChecked()
+	if err != nil {
+		t.Fatal(err)
+	} else if !VerifyProof(merkleRoot, proofSet, proofIndex, n) {
+		t.Fatal("ProveChecked produced an invalid proof")
+	}
+}
@@ -0,0 +1,98 @@
+package merkletree
+
+import (
+	"golang.org/x/crypto/blake2b"
+)
+
+// A Hasher wraps a Tree and implements hash.Hash, so that the Merkle root of a
+// stream can be computed anywhere a hash.Hash or io.Writer is accepted, e.g.
+// with io.Copy or io.MultiWriter. Written data is split into leaves of
+// segmentSize bytes in the same way as ReadAll, and Sum appends the Merkle
+// root of everything written so far, including a final leaf shorter than
+// segmentSize if the data does not end on a segment boundary.
+//
+// As with ReaderRoot, the Merkle root of an empty stream is the zero hash.
+type Hasher struct {
+	tree        *Tree
+	segmentSize int
+
+	// buf holds the data of the current, incomplete leaf.
+	buf []byte
+}
+
+// NewHasher returns a Hasher that splits the written data into leaves of
+// segmentSize bytes.
+func NewHasher(segmentSize int) *Hasher {
+	if segmentSize <= 0 {
+		panic("NewHasher: segmentSize must be positive")
+	}
+	return &Hasher{
+		tree:        New(),
+		segmentSize: segmentSize,
+		buf:         make([]byte, 0, segmentSize),
+	}
+}
+
+// Write implements io.Writer. It never returns an error.
+func (hs *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	// Complete the buffered leaf first.
+	if len(hs.buf) > 0 {
+		m := copy(hs.buf[len(hs.buf):hs.segmentSize], p)
+		hs.buf = hs.buf[:len(hs.buf)+m]
+		p = p[m:]
+		if len(hs.buf) < hs.segmentSize {
+			return n, nil
+		}
+		hs.tree.Push(hs.buf)
+		hs.buf = hs.buf[:0]
+	}
+	// Push complete leaves straight from p, and buffer the remainder.
+	for len(p) >= hs.segmentSize {
+		hs.tree.Push(p[:hs.segmentSize])
+		p = p[hs.segmentSize:]
+	}
+	hs.buf = append(hs.buf, p...)
+	return n, nil
+}
+
+// Sum implements hash.Hash. It appends the Merkle root of the data written so
+// far to b and returns the resulting slice. It does not change the state of
+// the Hasher.
+func (hs *Hasher) Sum(b []byte) []byte {
+	root := hs.Root()
+	return append(b, root[:]...)
+}
+
+// Root returns the Merkle root of the data written so far. It does not change
+// the state of the Hasher.
+func (hs *Hasher) Root() [32]byte {
+	if len(hs.buf) == 0 {
+		return hs.tree.Root()
+	}
+	// The buffered leaf can't be pushed without changing the Tree. Instead,
+	// join it with the subtrees on the stack, from the smallest to the
+	// largest; this is exactly what pushing it and calling Root would do.
+	root := LeafSum(hs.buf)
+	for i := len(hs.tree.stack) - 1; i >= 0; i-- {
+		root = nodeSum(hs.tree.stack[i].sum, root)
+	}
+	return root
+}
+
+// Reset implements hash.Hash. It reuses the memory of the Hasher, so
+// hashing another stream of the same size does not allocate.
+func (hs *Hasher) Reset() {
+	hs.tree.reset()
+	hs.buf = hs.buf[:0]
+}
+
+// Size implements hash.Hash. It returns the size of a BLAKE2b-256 hash.
+func (hs *Hasher) Size() int {
+	return blake2b.Size256
+}
+
+// BlockSize implements hash.Hash. It returns the block size of BLAKE2b.
+func (hs *Hasher) BlockSize() int {
+	return blake2b.BlockSize
+}
@@ -0,0 +1,76 @@
+package merkletree
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+	"golang.org/x/crypto/blake2b"
+)
+
+// TestHasher tests that a Hasher produces the same roots as ReaderRoot,
+// regardless of how the data is split across calls to Write.
+func TestHasher(t *testing.T) {
+	var _ hash.Hash = (*Hasher)(nil)
+	const segmentSize = 64
+	for _, size := range []int{0, 1, 63, 64, 65, 128, 1000, 64 * 37} {
+		data := fastrand.Bytes(size)
+		expRoot, err := ReaderRoot(bytes.NewReader(data), segmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// write the data in random chunks, checking the intermediate roots
+		hs := NewHasher(segmentSize)
+		for rem := data; len(rem) > 0; {
+			n := fastrand.Intn(len(rem)) + 1
+			if fastrand.Intn(2) == 0 && n > 3*segmentSize {
+				n = 3 * segmentSize
+			}
+			hs.Write(rem[:n])
+			rem = rem[n:]
+			written := data[:len(data)-len(rem)]
+			if root, _ := ReaderRoot(bytes.NewReader(written), segmentSize); !bytes.Equal(hs.Sum(nil), root[:]) {
+				t.Fatalf("wrong intermediate root after writing %v bytes", len(written))
+			}
+		}
+		if !bytes.Equal(hs.Sum(nil), expRoot[:]) {
+			t.Fatalf("wrong root for %v bytes", size)
+		}
+
+		// io.Copy should produce the same root after a Reset
+		hs.Reset()
+		if _, err := io.Copy(hs, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		} else if hs.Root() != expRoot {
+			t.Fatalf("wrong root for %v bytes after Reset", size)
+		}
+	}
+
+	hs := NewHasher(64)
+	if hs.Size() != blake2b.Size256 || hs.BlockSize() != blake2b.BlockSize {
+		t.Fatal("Hasher should have the size and block size of BLAKE2b")
+	}
+	prefix := []byte("prefix")
+	hs.Write([]byte("foo"))
+	if sum := hs.Sum(prefix); !bytes.HasPrefix(sum, prefix) || len(sum) != len(prefix)+blake2b.Size256 {
+		t.Fatal("Sum should append the root to its argument")
+	}
+}
+
+// TestHasherResetAllocs tests that reusing a Hasher via Reset does not
+// allocate.
+func TestHasherResetAllocs(t *testing.T) {
+	hs := NewHasher(64)
+	data := fastrand.Bytes(64 * 100)
+	hs.Write(data)
+	hs.Reset()
+	if allocs := testing.AllocsPerRun(100, func() {
+		hs.Write(data)
+		hs.Reset()
+	}); allocs != 0 {
+		t.Error("Write and Reset should not allocate, got", allocs)
+	}
+}
@@ -0,0 +1,172 @@
+package merkletree
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// An MMR is a Merkle Mountain Range: an append-only list of leaves whose
+// Merkle commitment is the list of its peaks, i.e. the roots of the largest
+// complete subtrees that the leaves can be split into. These are the same
+// subtrees that a Tree holds in its stack, and the peaks can be bagged into
+// the same root.
+//
+// Unlike the root, a peak never changes once it has been formed; it can only
+// become the child of a larger peak. An inclusion proof against a peak
+// therefore remains valid as the MMR grows until its peak is merged. At that
+// point the proof can be brought up to date with ProveFrom, which returns
+// only the missing hashes at the top of the proof.
+type MMR struct {
+	// nodes holds the roots of all of the complete subtrees of the MMR;
+	// nodes[i] holds the roots of the subtrees of height i, from left to
+	// right. nodes[0] holds the leaf hashes.
+	nodes [][][32]byte
+}
+
+// NewMMR returns an empty MMR.
+func NewMMR() *MMR {
+	return &MMR{}
+}
+
+// mmrPeak returns the height of the peak that covers the leaf at the
+// specified index in an MMR of numLeaves leaves, along with the position of
+// the peak in the list of peaks. index must be less than numLeaves.
+func mmrPeak(numLeaves, index uint64) (height, position int) {
+	var start uint64
+	for height = bits.Len64(numLeaves) - 1; ; height-- {
+		if numLeaves&(1<<uint(height)) == 0 {
+			continue
+		}
+		start += 1 << uint(height)
+		if index < start {
+			return height, position
+		}
+		position++
+	}
+}
+
+// NumLeaves returns the number of leaves in the MMR.
+func (m *MMR) NumLeaves() uint64 {
+	if len(m.nodes) == 0 {
+		return 0
+	}
+	return uint64(len(m.nodes[0]))
+}
+
+// Append adds a leaf to the end of the MMR.
+func (m *MMR) Append(data []byte) {
+	m.AppendLeafHash(LeafSum(data))
+}
+
+// AppendLeafHash adds the hash of a leaf, as produced by LeafSum, to the end
+// of the MMR.
+func (m *MMR) AppendLeafHash(leafHash [32]byte) {
+	current := subTree{
+		height: 0,
+		sum:    leafHash,
+	}
+	// Every second subtree of a given height completes a subtree of the
+	// next height.
+	for {
+		if current.height == len(m.nodes) {
+			m.nodes = append(m.nodes, nil)
+		}
+		level := append(m.nodes[current.height], current.sum)
+		m.nodes[current.height] = level
+		if len(level)%2 == 1 {
+			return
+		}
+		left := subTree{
+			height: current.height,
+			sum:    level[len(level)-2],
+		}
+		current = joinSubTrees(left, current)
+	}
+}
+
+// Peaks returns the peaks of the MMR, from the largest to the smallest. The
+// heights of the peaks are given by the bits of NumLeaves.
+func (m *MMR) Peaks() [][32]byte {
+	numLeaves := m.NumLeaves()
+	var peaks [][32]byte
+	for height := len(m.nodes) - 1; height >= 0; height-- {
+		if numLeaves&(1<<uint(height)) != 0 {
+			level := m.nodes[height]
+			peaks = append(peaks, level[len(level)-1])
+		}
+	}
+	return peaks
+}
+
+// Root returns the Merkle root of the MMR, which is the same as the root of a
+// Tree into which the leaves were pushed in order.
+func (m *MMR) Root() [32]byte {
+	return BagPeaks(m.Peaks())
+}
+
+// Prove returns a proof that the leaf at the specified index is covered by
+// its peak. The proof holds the sibling hashes on the path from the leaf to
+// the peak, ordered from the leaf to the peak.
+func (m *MMR) Prove(index uint64) ([][32]byte, error) {
+	return m.ProveFrom(index, 0)
+}
+
+// ProveFrom returns the part of the proof for the leaf at the specified index
+// that begins at the specified height, i.e. the hashes that must be appended
+// to a proof of length height that was built when the MMR was smaller, and
+// whose peak has since been merged, to make it a proof against the current
+// peak of the leaf.
+func (m *MMR) ProveFrom(index uint64, height int) ([][32]byte, error) {
+	numLeaves := m.NumLeaves()
+	if index >= numLeaves {
+		return nil, errors.New("leaf index is not in the MMR")
+	}
+	peakHeight, _ := mmrPeak(numLeaves, index)
+	if height < 0 || height > peakHeight {
+		return nil, errors.New("proof height exceeds the height of the peak")
+	}
+	var proof [][32]byte
+	for ; height < peakHeight; height++ {
+		sibling := (index >> uint(height)) ^ 1
+		proof = append(proof, m.nodes[height][sibling])
+	}
+	return proof, nil
+}
+
+// BagPeaks returns the Merkle root of an MMR with the specified peaks,
+// ordered from the largest to the smallest, joining the peaks in the same
+// way as (*Tree).Root. It returns the zero hash if there are no peaks.
+func BagPeaks(peaks [][32]byte) [32]byte {
+	if len(peaks) == 0 {
+		return [32]byte{}
+	}
+	current := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		current = nodeSum(peaks[i], current)
+	}
+	return current
+}
+
+// VerifyMMRProof verifies a proof produced by Prove, showing that the leaf
+// data is at the specified index of an MMR of numLeaves leaves with the
+// specified peaks. Only the peak that covers the leaf is used, so the proof
+// remains valid for any later numLeaves and peaks in which that peak has not
+// been merged.
+func VerifyMMRProof(peaks [][32]byte, numLeaves, index uint64, data []byte, proof [][32]byte) bool {
+	if index >= numLeaves || len(peaks) != bits.OnesCount64(numLeaves) {
+		return false
+	}
+	height, position := mmrPeak(numLeaves, index)
+	if len(proof) != height {
+		return false
+	}
+	current := LeafSum(data)
+	for i, sibling := range proof {
+		if (index>>uint(i))&1 == 0 {
+			current = nodeSum(current, sibling)
+		} else {
+			current = nodeSum(sibling, current)
+		}
+	}
+	return current == peaks[position]
+}
@@ -0,0 +1,349 @@
+package merkletree
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// proofVersion is the version of the encoding produced by
+// (*Proof).MarshalBinary. It is the first byte of every encoded proof.
+const proofVersion = 1
+
+// HashBLAKE2b256 is the identifier of the hash function recorded in every
+// encoded proof. It matches the identifier used by the merkletree package, so
+// that proofs encoded by this package can be verified there as well.
+const HashBLAKE2b256 = "blake2b-256"
+
+// A ProofKind identifies the kind of proof held by a Proof.
+type ProofKind uint8
+
+const (
+	// ProofSingle is a proof for a single leaf, as produced by (*Tree).Prove.
+	ProofSingle ProofKind = iota + 1
+	// ProofRange is a proof for a set of leaf ranges, as produced by
+	// BuildMultiRangeProof.
+	ProofRange
+	// ProofDiff is a diff proof, as produced by BuildDiffProof.
+	ProofDiff
+)
+
+// String implements fmt.Stringer.
+func (k ProofKind) String() string {
+	switch k {
+	case ProofSingle:
+		return "single"
+	case ProofRange:
+		return "range"
+	case ProofDiff:
+		return "diff"
+	default:
+		return fmt.Sprintf("ProofKind(%d)", uint8(k))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k ProofKind) MarshalText() ([]byte, error) {
+	switch k {
+	case ProofSingle, ProofRange, ProofDiff:
+		return []byte(k.String()), nil
+	default:
+		return nil, fmt.Errorf("invalid proof kind %d", uint8(k))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *ProofKind) UnmarshalText(b []byte) error {
+	for _, kind := range []ProofKind{ProofSingle, ProofRange, ProofDiff} {
+		if string(b) == kind.String() {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid proof kind %q", b)
+}
+
+// A Proof is a self-describing Merkle proof. Along with the proof hashes, it
+// records the kind of proof and everything else that the verifier of that
+// kind of proof needs, so that the proof can be stored or sent as a single
+// value and verified with Verify.
+//
+// A Proof can be encoded with MarshalBinary, using a versioned binary
+// encoding in which every list and byte slice is prefixed with its length,
+// or with encoding/json. Both encodings are the same as those of the
+// merkletree package, with the hash function recorded as HashBLAKE2b256.
+type Proof struct {
+	Kind ProofKind
+	// NumLeaves is the number of leaves in the tree. It is required for
+	// single and diff proofs. For range proofs it is optional; if it is not
+	// zero, the proof is verified with VerifyMultiRangeProofWithSize.
+	NumLeaves uint64
+	// Index is the index of the leaf of a single proof.
+	Index uint64
+	// Ranges are the leaf ranges of a range or diff proof.
+	Ranges []LeafRange
+	// Data is the leaf data of a single proof.
+	Data []byte
+	// Leaves are the leaf hashes within the ranges of a range proof, or the
+	// subtree hashes within the ranges of a diff proof, as produced by
+	// CompressLeafHashes.
+	Leaves [][32]byte
+	// Hashes are the proof hashes.
+	Hashes [][32]byte
+}
+
+// NewSingleProof returns a Proof holding the leaf data and proof set
+// produced by (*Tree).Prove.
+func NewSingleProof(base []byte, proofSet [][32]byte, proofIndex, numLeaves uint64) *Proof {
+	p := &Proof{
+		Kind:      ProofSingle,
+		NumLeaves: numLeaves,
+		Index:     proofIndex,
+		Data:      base,
+	}
+	// The first element of the proof set is the hash of the leaf data, which
+	// is recomputed by Verify.
+	if len(proofSet) > 0 {
+		p.Hashes = proofSet[1:]
+	}
+	return p
+}
+
+// NewRangeProof returns a Proof holding a proof produced by
+// BuildMultiRangeProof together with the leaf hashes within the ranges. If
+// numLeaves is not zero, the proof is verified against that number of leaves.
+func NewRangeProof(ranges []LeafRange, leafHashes, proof [][32]byte, numLeaves uint64) *Proof {
+	return &Proof{
+		Kind:      ProofRange,
+		NumLeaves: numLeaves,
+		Ranges:    ranges,
+		Leaves:    leafHashes,
+		Hashes:    proof,
+	}
+}
+
+// NewDiffProof returns a Proof holding a proof produced by BuildDiffProof
+// together with the subtree hashes within the ranges.
+func NewDiffProof(ranges []LeafRange, rangeHashes, proof [][32]byte, numLeaves uint64) *Proof {
+	return &Proof{
+		Kind:      ProofDiff,
+		NumLeaves: numLeaves,
+		Ranges:    ranges,
+		Leaves:    rangeHashes,
+		Hashes:    proof,
+	}
+}
+
+// Verify verifies the proof against the Merkle root, using the verifier of
+// its kind. An error is returned if the proof is malformed, e.g. if its
+// ranges are invalid.
+func (p *Proof) Verify(root [32]byte) (bool, error) {
+	switch p.Kind {
+	case ProofSingle:
+		proofSet := append([][32]byte{LeafSum(p.Data)}, p.Hashes...)
+		return VerifyProof(root, proofSet, p.Index, p.NumLeaves), nil
+	case ProofRange:
+		if err := checkRangeSet(p.Ranges); err != nil {
+			return false, err
+		}
+		var numRangeLeaves uint64
+		for _, r := range p.Ranges {
+			numRangeLeaves += r.End - r.Start
+		}
+		if uint64(len(p.Leaves)) < numRangeLeaves {
+			return false, ErrProofTooShort
+		} else if uint64(len(p.Leaves)) > numRangeLeaves {
+			return false, ErrProofTooLong
+		}
+		lh := NewCachedLeafHasher(p.Leaves)
+		if p.NumLeaves != 0 {
+			return VerifyMultiRangeProofWithSize(lh, p.Ranges, p.Hashes, root, p.NumLeaves)
+		}
+		return VerifyMultiRangeProofChecked(lh, p.Ranges, p.Hashes, root)
+	case ProofDiff:
+		return VerifyDiffProofChecked(p.Leaves, p.NumLeaves, p.Ranges, p.Hashes, root)
+	default:
+		return false, fmt.Errorf("invalid proof kind %d", uint8(p.Kind))
+	}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	if _, err := p.Kind.MarshalText(); err != nil {
+		return nil, err
+	}
+	e := checkpointEncoder{proofVersion, byte(p.Kind)}
+	e.writePrefixedBytes([]byte(HashBLAKE2b256))
+	e.writeUint64(p.NumLeaves)
+	e.writeUint64(p.Index)
+	e.writeUint64(uint64(len(p.Ranges)))
+	for _, r := range p.Ranges {
+		e.writeUint64(r.Start)
+		e.writeUint64(r.End)
+	}
+	// The leaf data of a single proof is stored in the list of leaves.
+	if p.Kind == ProofSingle {
+		e.writeUint64(1)
+		e.writePrefixedBytes(p.Data)
+	} else {
+		e.writeUint64(uint64(len(p.Leaves)))
+		for _, h := range p.Leaves {
+			e.writePrefixedBytes(h[:])
+		}
+	}
+	e.writeUint64(uint64(len(p.Hashes)))
+	for _, h := range p.Hashes {
+		e.writePrefixedBytes(h[:])
+	}
+	return e, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *Proof) UnmarshalBinary(b []byte) error {
+	d := checkpointDecoder{buf: b}
+	header := d.readBytes(2)
+	if d.err != nil {
+		return d.err
+	} else if header[0] != proofVersion {
+		return fmt.Errorf("unsupported proof version %v", header[0])
+	}
+	kind := ProofKind(header[1])
+	if _, err := kind.MarshalText(); err != nil {
+		return err
+	}
+	hashID := string(d.readPrefixedBytes())
+	if d.err == nil && hashID != HashBLAKE2b256 {
+		return fmt.Errorf("unsupported hash function %q", hashID)
+	}
+	numLeaves := d.readUint64()
+	index := d.readUint64()
+
+	// Every element takes up at least 8 bytes, so a count that exceeds the
+	// remaining bytes is invalid. Checking this up front prevents large
+	// allocations when decoding garbage.
+	readCount := func(elemSize int) int {
+		n := d.readUint64()
+		if d.err == nil && n > uint64(len(d.buf)/elemSize) {
+			d.err = errors.New("proof contains too many elements")
+		}
+		return int(n)
+	}
+	var ranges []LeafRange
+	for i, n := 0, readCount(16); i < n && d.err == nil; i++ {
+		ranges = append(ranges, LeafRange{d.readUint64(), d.readUint64()})
+	}
+	var lists [2][][]byte
+	for j := range lists {
+		for i, n := 0, readCount(8); i < n && d.err == nil; i++ {
+			lists[j] = append(lists[j], d.readPrefixedBytes())
+		}
+	}
+	if d.err != nil {
+		return d.err
+	} else if len(d.buf) != 0 {
+		return errors.New("proof has trailing bytes")
+	}
+
+	q := Proof{
+		Kind:      kind,
+		NumLeaves: numLeaves,
+		Index:     index,
+		Ranges:    ranges,
+	}
+	var err error
+	if kind == ProofSingle {
+		if len(lists[0]) != 1 {
+			return errors.New("single proof must contain exactly one leaf")
+		}
+		q.Data = lists[0][0]
+	} else if q.Leaves, err = toHashes(lists[0]); err != nil {
+		return err
+	}
+	if q.Hashes, err = toHashes(lists[1]); err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
+
+// toHashes converts a list of byte slices into a list of hashes.
+func toHashes(bs [][]byte) ([][32]byte, error) {
+	if bs == nil {
+		return nil, nil
+	}
+	hashes := make([][32]byte, len(bs))
+	for i, b := range bs {
+		if len(b) != len(hashes[i]) {
+			return nil, errors.New("proof contains a hash of the wrong size")
+		}
+		copy(hashes[i][:], b)
+	}
+	return hashes, nil
+}
+
+// fromHashes converts a list of hashes into a list of byte slices.
+func fromHashes(hashes [][32]byte) [][]byte {
+	if hashes == nil {
+		return nil
+	}
+	bs := make([][]byte, len(hashes))
+	for i := range hashes {
+		bs[i] = hashes[i][:]
+	}
+	return bs
+}
+
+// proofJSON is the JSON encoding of a Proof. Hashes are encoded as byte
+// slices, i.e. as base64 strings, rather than as arrays of numbers.
+type proofJSON struct {
+	Kind      ProofKind   `json:"kind"`
+	Hash      string      `json:"hash"`
+	NumLeaves uint64      `json:"numLeaves"`
+	Index     uint64      `json:"index"`
+	Ranges    []LeafRange `json:"ranges,omitempty"`
+	Data      []byte      `json:"data,omitempty"`
+	Leaves    [][]byte    `json:"leaves,omitempty"`
+	Hashes    [][]byte    `json:"hashes"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p Proof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(proofJSON{
+		Kind:      p.Kind,
+		Hash:      HashBLAKE2b256,
+		NumLeaves: p.NumLeaves,
+		Index:     p.Index,
+		Ranges:    p.Ranges,
+		Data:      p.Data,
+		Leaves:    fromHashes(p.Leaves),
+		Hashes:    fromHashes(p.Hashes),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Proof) UnmarshalJSON(b []byte) error {
+	var pj proofJSON
+	if err := json.Unmarshal(b, &pj); err != nil {
+		return err
+	} else if pj.Hash != HashBLAKE2b256 {
+		return fmt.Errorf("unsupported hash function %q", pj.Hash)
+	}
+	leaves, err := toHashes(pj.Leaves)
+	if err != nil {
+		return err
+	}
+	hashes, err := toHashes(pj.Hashes)
+	if err != nil {
+		return err
+	}
+	*p = Proof{
+		Kind:      pj.Kind,
+		NumLeaves: pj.NumLeaves,
+		Index:     pj.Index,
+		Ranges:    pj.Ranges,
+		Data:      pj.Data,
+		Leaves:    leaves,
+		Hashes:    hashes,
+	}
+	return nil
+}
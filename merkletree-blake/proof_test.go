@@ -0,0 +1,155 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+	shamerkletree "github.com/uplo-tech/merkletree"
+)
+
+// testProofs returns one proof of every kind for the specified data, along
+// with the Merkle root of the data.
+func testProofs(t *testing.T, data []byte, leafSize int) ([]*Proof, [32]byte) {
+	numLeaves := uint64(len(data) / leafSize)
+	root := bytesRoot(data, leafSize)
+	leafHashes := make([][32]byte, numLeaves)
+	for i := range leafHashes {
+		leafHashes[i] = LeafSum(data[i*leafSize:][:leafSize])
+	}
+
+	tree := New()
+	if err := tree.SetIndex(numLeaves / 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ReadAll(bytes.NewReader(data), leafSize); err != nil {
+		t.Fatal(err)
+	}
+	_, base, proofSet, proofIndex, n := tree.Prove()
+
+	ranges := []LeafRange{{1, 4}, {9, 10}}
+	var rangeLeaves [][32]byte
+	for _, r := range ranges {
+		rangeLeaves = append(rangeLeaves, leafHashes[r.Start:r.End]...)
+	}
+	rangeProof, err := BuildMultiRangeProof(ranges, NewCachedSubtreeHasher(leafHashes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffProof, err := BuildDiffProof(ranges, NewCachedSubtreeHasher(leafHashes), numLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rangeHashes, err := CompressLeafHashes(ranges, NewCachedSubtreeHasher(rangeLeaves))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []*Proof{
+		NewSingleProof(base, proofSet, proofIndex, n),
+		NewRangeProof(ranges, rangeLeaves, rangeProof, 0),
+		NewRangeProof(ranges, rangeLeaves, rangeProof, numLeaves),
+		NewDiffProof(ranges, rangeHashes, diffProof, numLeaves),
+	}, root
+}
+
+// TestProofEncoding tests that proofs of every kind survive the binary and
+// JSON encodings and still verify afterwards, both in this package and in the
+// merkletree package.
+func TestProofEncoding(t *testing.T) {
+	const leafSize = 16
+	data := fastrand.Bytes(leafSize * 13)
+	proofs, root := testProofs(t, data, leafSize)
+	badRoot := root
+	badRoot[0] ^= 1
+	for _, p := range proofs {
+		if ok, err := p.Verify(root); !ok || err != nil {
+			t.Fatalf("%v proof failed to verify: %v", p.Kind, err)
+		}
+		if ok, _ := p.Verify(badRoot); ok {
+			t.Fatalf("%v proof verified against the wrong root", p.Kind)
+		}
+
+		b, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bp Proof
+		if err := bp.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(&bp, p) {
+			t.Fatalf("%v proof changed after binary encoding", p.Kind)
+		}
+		js, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var jp Proof
+		if err := json.Unmarshal(js, &jp); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(&jp, p) {
+			t.Fatalf("%v proof changed after JSON encoding: %s", p.Kind, js)
+		}
+
+		// the merkletree package should be able to verify the proof
+		var sp shamerkletree.Proof
+		if err := sp.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		} else if ok, err := sp.Verify(root[:]); !ok || err != nil {
+			t.Fatalf("%v proof failed to verify in the merkletree package: %v", p.Kind, err)
+		}
+		if err := json.Unmarshal(js, &sp); err != nil {
+			t.Fatal(err)
+		} else if ok, err := sp.Verify(root[:]); !ok || err != nil {
+			t.Fatalf("%v proof failed to verify in the merkletree package: %v", p.Kind, err)
+		}
+
+		// every truncation of the encoding should be rejected
+		for i := 0; i < len(b); i++ {
+			if err := new(Proof).UnmarshalBinary(b[:i]); err == nil {
+				t.Fatalf("truncated %v proof of %v bytes was accepted", p.Kind, i)
+			}
+		}
+	}
+}
+
+// TestProofErrors tests that malformed proofs are rejected.
+func TestProofErrors(t *testing.T) {
+	const leafSize = 16
+	data := fastrand.Bytes(leafSize * 13)
+	proofs, root := testProofs(t, data, leafSize)
+	rangeProof, diff := proofs[1], proofs[3]
+
+	// a proof using a different hash function can't be decoded
+	sp := shamerkletree.NewRangeProof(shamerkletree.HashSHA256, []shamerkletree.LeafRange{{Start: 0, End: 1}}, [][]byte{make([]byte, 32)}, nil, 0)
+	b, _ := sp.MarshalBinary()
+	if err := new(Proof).UnmarshalBinary(b); err == nil {
+		t.Error("expected error for unsupported hash function")
+	}
+	js, _ := json.Marshal(sp)
+	if err := json.Unmarshal(js, new(Proof)); err == nil {
+		t.Error("expected error for unsupported hash function")
+	}
+	// neither can a proof with hashes of the wrong size
+	sp = shamerkletree.NewRangeProof(HashBLAKE2b256, []shamerkletree.LeafRange{{Start: 0, End: 1}}, [][]byte{make([]byte, 31)}, nil, 0)
+	b, _ = sp.MarshalBinary()
+	if err := new(Proof).UnmarshalBinary(b); err == nil {
+		t.Error("expected error for hash of the wrong size")
+	}
+
+	p := *rangeProof
+	p.Leaves = p.Leaves[1:]
+	if _, err := p.Verify(root); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort, got", err)
+	}
+	p = *diff
+	p.Hashes = append(p.Hashes, root)
+	if _, err := p.Verify(root); err != ErrProofTooLong {
+		t.Error("expected ErrProofTooLong, got", err)
+	}
+	if _, err := (&Proof{Kind: 7}).Verify(root); err == nil {
+		t.Error("expected error for invalid kind")
+	}
+}
@@ -0,0 +1,91 @@
+package sparse
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// Prove returns a proof for the leaf of the key: the hashes of the siblings
+// of the nodes on the path from the leaf to the root, ordered from the leaf
+// to the root. If the key is in the tree, the proof can be verified with
+// VerifyInclusionProof; otherwise it can be verified with
+// VerifyNonInclusionProof.
+func (t *Tree) Prove(key [KeySize]byte) [][32]byte {
+	proof := make([][32]byte, Depth)
+	for height := range proof {
+		proof[height] = t.nodes[sibling(key, height)]
+	}
+	return proof
+}
+
+// ProveCompressed returns the same proof as Prove, with the empty sibling
+// hashes removed.
+func (t *Tree) ProveCompressed(key [KeySize]byte) CompressedProof {
+	return CompressProof(t.Prove(key))
+}
+
+// verifyProof checks that the root of the tree containing the leaf hash at
+// the position of the key matches the specified root.
+func verifyProof(root [32]byte, key [KeySize]byte, leaf [32]byte, proof [][32]byte) bool {
+	if len(proof) != Depth {
+		return false
+	}
+	return rootFromLeaf(key, leaf, proof) == root
+}
+
+// VerifyInclusionProof verifies a proof produced by Prove, showing that the
+// key has the specified value in the tree with the specified root.
+func VerifyInclusionProof(root [32]byte, key [KeySize]byte, value []byte, proof [][32]byte) bool {
+	return verifyProof(root, key, leafSum(key, value), proof)
+}
+
+// VerifyNonInclusionProof verifies a proof produced by Prove, showing that
+// the key is not in the tree with the specified root.
+func VerifyNonInclusionProof(root [32]byte, key [KeySize]byte, proof [][32]byte) bool {
+	return verifyProof(root, key, [32]byte{}, proof)
+}
+
+// A CompressedProof is a proof from which the empty sibling hashes have been
+// removed. In a tree with n keys, all but about log2(n) of the siblings on
+// the path to a leaf are usually empty.
+type CompressedProof struct {
+	// Bitmap has a bit for every sibling of the full proof, in the same
+	// order; bit i is (Bitmap[i/8] >> (i%8)) & 1. The bit is set if the
+	// sibling is not empty.
+	Bitmap [Depth / 8]byte
+	// Siblings holds the siblings that are not empty.
+	Siblings [][32]byte
+}
+
+// CompressProof removes the empty sibling hashes from a proof produced by
+// Prove.
+func CompressProof(proof [][32]byte) CompressedProof {
+	var cp CompressedProof
+	for i, s := range proof {
+		if s != ([32]byte{}) {
+			cp.Bitmap[i/8] |= 1 << uint(i%8)
+			cp.Siblings = append(cp.Siblings, s)
+		}
+	}
+	return cp
+}
+
+// DecompressProof restores the full proof from a compressed proof.
+func DecompressProof(cp CompressedProof) ([][32]byte, error) {
+	var numSiblings int
+	for _, b := range cp.Bitmap {
+		numSiblings += bits.OnesCount8(b)
+	}
+	if numSiblings != len(cp.Siblings) {
+		return nil, errors.New("number of siblings does not match the bitmap")
+	}
+	proof := make([][32]byte, Depth)
+	siblings := cp.Siblings
+	for i := range proof {
+		if cp.Bitmap[i/8]&(1<<uint(i%8)) != 0 {
+			proof[i] = siblings[0]
+			siblings = siblings[1:]
+		}
+	}
+	return proof, nil
+}
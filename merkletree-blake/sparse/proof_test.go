@@ -0,0 +1,71 @@
+package sparse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestProofs tests that inclusion and non-inclusion proofs, both full and
+// compressed, can be verified.
+func TestProofs(t *testing.T) {
+	tree := New()
+	keys := make([][KeySize]byte, 30)
+	for i := range keys {
+		keys[i] = randomKey()
+		tree.Update(keys[i], fastrand.Bytes(8))
+	}
+	root := tree.Root()
+
+	for _, key := range keys {
+		value, _ := tree.Get(key)
+		proof := tree.Prove(key)
+		if !VerifyInclusionProof(root, key, value, proof) {
+			t.Fatal("valid inclusion proof was rejected")
+		} else if VerifyInclusionProof(root, key, []byte("wrong"), proof) {
+			t.Fatal("inclusion proof verified with the wrong value")
+		} else if VerifyNonInclusionProof(root, key, proof) {
+			t.Fatal("non-inclusion proof verified for a key in the tree")
+		}
+
+		cp := tree.ProveCompressed(key)
+		if len(cp.Siblings) > 20 {
+			t.Fatalf("compressed proof has %v siblings", len(cp.Siblings))
+		}
+		full, err := DecompressProof(cp)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(full, proof) {
+			t.Fatal("decompressed proof does not match the full proof")
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		key := randomKey()
+		proof := tree.Prove(key)
+		if !VerifyNonInclusionProof(root, key, proof) {
+			t.Fatal("valid non-inclusion proof was rejected")
+		} else if VerifyInclusionProof(root, key, nil, proof) {
+			t.Fatal("inclusion proof verified for a key that is not in the tree")
+		}
+		// the proof must not verify for another key
+		other := key
+		other[0] ^= 0x80
+		if VerifyNonInclusionProof(root, other, proof) {
+			t.Fatal("non-inclusion proof verified for the wrong key")
+		}
+	}
+
+	// malformed proofs should be rejected
+	proof := tree.Prove(keys[0])
+	value, _ := tree.Get(keys[0])
+	if VerifyInclusionProof(root, keys[0], value, proof[1:]) {
+		t.Error("proof with too few siblings was accepted")
+	}
+	cp := tree.ProveCompressed(keys[0])
+	cp.Siblings = cp.Siblings[1:]
+	if _, err := DecompressProof(cp); err == nil {
+		t.Error("expected error when the bitmap does not match the siblings")
+	}
+}
@@ -0,0 +1,166 @@
+// Package sparse provides a sparse Merkle tree, which commits to a mapping
+// from 256-bit keys to values. Every possible key has a leaf in the tree, at
+// the position given by the bits of the key, and the leaves of keys that are
+// not in the mapping are empty. This allows proving both that a key has a
+// certain value and that a key is not in the mapping.
+//
+// The tree uses the same domain separation as package merkletree: a leaf is
+// hashed as BLAKE2b(0x00 || key || value), and a node as BLAKE2b(0x01 ||
+// left child || right child). An empty subtree has the all-zero hash, and a
+// node whose children are both empty is empty itself, so that the tree can
+// be stored and proven without computing hashes for the empty parts of the
+// tree.
+package sparse
+
+import (
+	"golang.org/x/crypto/blake2b"
+)
+
+// KeySize is the size of a key in bytes. Keys of other sizes, or keys that
+// are not uniformly distributed, should be hashed before being used as keys.
+const KeySize = 32
+
+// Depth is the number of levels below the root of the tree.
+const Depth = KeySize * 8
+
+var (
+	// prefixes used during hashing, the same as those of package merkletree
+	leafHashPrefix = []byte{0x00}
+	nodeHashPrefix = []byte{0x01}
+)
+
+// A nodeID identifies a node of the tree by its height above the leaves and
+// the bits of the path leading to it, i.e. a key whose lowest 'height' bits
+// are zero.
+type nodeID struct {
+	height int
+	path   [KeySize]byte
+}
+
+// bit returns the bit of the key that determines whether the node at the
+// specified height on the path to the key's leaf is a left (0) or right (1)
+// child.
+func bit(key [KeySize]byte, height int) int {
+	i := Depth - 1 - height
+	return int(key[i/8]>>(7-uint(i%8))) & 1
+}
+
+// ancestor returns the ID of the node at the specified height on the path to
+// the key's leaf.
+func ancestor(key [KeySize]byte, height int) nodeID {
+	for i := Depth - height; i < Depth; i++ {
+		key[i/8] &^= 1 << (7 - uint(i%8))
+	}
+	return nodeID{height: height, path: key}
+}
+
+// sibling returns the ID of the sibling of the node at the specified height
+// on the path to the key's leaf.
+func sibling(key [KeySize]byte, height int) nodeID {
+	id := ancestor(key, height)
+	i := Depth - 1 - height
+	id.path[i/8] ^= 1 << (7 - uint(i%8))
+	return id
+}
+
+// A Tree is a sparse Merkle tree. Only the non-empty nodes of the tree are
+// stored, so the memory used by the tree grows with the number of keys.
+type Tree struct {
+	root   [32]byte
+	nodes  map[nodeID][32]byte
+	values map[[KeySize]byte][]byte
+}
+
+// New creates a new, empty Tree.
+func New() *Tree {
+	return &Tree{
+		nodes:  make(map[nodeID][32]byte),
+		values: make(map[[KeySize]byte][]byte),
+	}
+}
+
+// leafSum returns the hash of the leaf of a key with the specified value.
+func leafSum(key [KeySize]byte, value []byte) [32]byte {
+	buf := make([]byte, 0, 1+KeySize+len(value))
+	buf = append(buf, leafHashPrefix...)
+	buf = append(buf, key[:]...)
+	buf = append(buf, value...)
+	return blake2b.Sum256(buf)
+}
+
+// nodeSum returns the hash of a node with the specified children. A node
+// whose children are both empty is empty itself.
+func nodeSum(left, right [32]byte) [32]byte {
+	if left == ([32]byte{}) && right == ([32]byte{}) {
+		return [32]byte{}
+	}
+	buf := make([]byte, 0, 65)
+	buf = append(buf, nodeHashPrefix...)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return blake2b.Sum256(buf)
+}
+
+// rootFromLeaf returns the root of the tree that contains the leaf hash at
+// the position of the key, given the sibling hashes along the path to the
+// root, ordered from the leaf to the root.
+func rootFromLeaf(key [KeySize]byte, leaf [32]byte, siblings [][32]byte) [32]byte {
+	current := leaf
+	for height, s := range siblings {
+		if bit(key, height) == 0 {
+			current = nodeSum(current, s)
+		} else {
+			current = nodeSum(s, current)
+		}
+	}
+	return current
+}
+
+// setLeaf sets the hash of the key's leaf and recomputes the nodes on the
+// path to the root.
+func (t *Tree) setLeaf(key [KeySize]byte, leaf [32]byte) {
+	current := leaf
+	for height := 0; height < Depth; height++ {
+		if current == ([32]byte{}) {
+			delete(t.nodes, ancestor(key, height))
+		} else {
+			t.nodes[ancestor(key, height)] = current
+		}
+		s := t.nodes[sibling(key, height)]
+		if bit(key, height) == 0 {
+			current = nodeSum(current, s)
+		} else {
+			current = nodeSum(s, current)
+		}
+	}
+	t.root = current
+}
+
+// Update sets the value of the key. The value is copied.
+func (t *Tree) Update(key [KeySize]byte, value []byte) {
+	value = append([]byte{}, value...)
+	t.values[key] = value
+	t.setLeaf(key, leafSum(key, value))
+}
+
+// Delete removes the key from the tree, leaving its leaf empty. Deleting a
+// key that is not in the tree has no effect.
+func (t *Tree) Delete(key [KeySize]byte) {
+	if _, ok := t.values[key]; !ok {
+		return
+	}
+	delete(t.values, key)
+	t.setLeaf(key, [32]byte{})
+}
+
+// Get returns the value of the key, and whether the key is in the tree.
+func (t *Tree) Get(key [KeySize]byte) ([]byte, bool) {
+	value, ok := t.values[key]
+	return value, ok
+}
+
+// Root returns the Merkle root of the tree. The root of an empty tree is the
+// all-zero hash.
+func (t *Tree) Root() [32]byte {
+	return t.root
+}
@@ -91,6 +91,25 @@ func New() *Tree {
 	}
 }
 
+// reset empties the Tree, leaving it in the same state as a Tree returned by
+// New (or NewCachedTree, for a cached tree), while keeping its stack for
+// reuse.
+func (t *Tree) reset() {
+	t.stack = t.stack[:0]
+	t.currentIndex = 0
+	t.proofIndex = 0
+	t.proofBase = nil
+	if t.proofTree {
+		// Prove shares the proof set with its caller.
+		t.proofSet = nil
+	} else {
+		// The proof set of a Tree without a proof index is never returned,
+		// so its memory can be reused.
+		t.proofSet = t.proofSet[:0]
+	}
+	t.proofTree = false
+}
+
 // Prove creates a proof that the leaf at the established index (established by
 // SetIndex) is an element of the Merkle tree. Prove will return a nil proof
 // set if used incorrectly. Prove does not modify the Tree. Prove can only be
@@ -160,6 +179,16 @@ func (t *Tree) Prove() (merkleRoot [32]byte, base []byte, proofSet [][32]byte, p
 	return t.Root(), t.proofBase, proofSet, t.proofIndex, t.currentIndex
 }
 
+// ProveChecked is the same as Prove, except that it returns ErrIndexNotSet
+// instead of panicking if SetIndex has not been called.
+func (t *Tree) ProveChecked() (merkleRoot [32]byte, base []byte, proofSet [][32]byte, proofIndex uint64, numLeaves uint64, err error) {
+	if !t.proofTree {
+		return [32]byte{}, nil, nil, 0, 0, ErrIndexNotSet
+	}
+	merkleRoot, base, proofSet, proofIndex, numLeaves = t.Prove()
+	return merkleRoot, base, proofSet, proofIndex, numLeaves, nil
+}
+
 // Push will add data to the set, building out the Merkle tree and Root. The
 // tree does not remember all elements that are added, instead only keeping the
 // log(n) elements that are necessary to build the Merkle root and keeping the
@@ -0,0 +1,135 @@
+package merkletree
+
+import (
+	"io"
+	"math"
+	"math/bits"
+)
+
+// A VerifyingReader reads the leaf data of a range proof from an underlying
+// stream, and only returns data that has been authenticated against the
+// Merkle root. As in VerifyMultiRangeProof, the proof hashes to the left of
+// the range are pushed onto a tree up front, and each leaf of the range is
+// hashed and pushed as soon as it has been read, so the data is never hashed
+// twice and the root is known as soon as the last leaf arrives.
+//
+// A range proof authenticates the leaves of its range only as a whole: the
+// root of any part of the range depends on the data of the rest of it. The
+// reader therefore holds back the data of the whole range, in a single
+// buffer, until it has been authenticated. To bound the amount of unverified
+// data, split a large download into several ranges, each with its own proof.
+type VerifyingReader struct {
+	r        io.Reader
+	leafSize int
+	start    uint64
+	end      uint64
+
+	// tree holds the proof hashes to the left of the range, followed by the
+	// leaves of the range that have been read. proof holds the proof hashes
+	// to the right of the range.
+	tree  *Tree
+	proof [][32]byte
+	root  [32]byte
+
+	buf []byte
+	err error
+}
+
+// NewVerifyingReader returns a VerifyingReader that reads the data of the
+// leaves [proofStart, proofEnd) from r. proof is the proof produced by
+// BuildRangeProof (or BuildMultiRangeProof) for the range. If the proof does
+// not contain the hashes to the left of the range, ErrProofTooShort is
+// returned.
+func NewVerifyingReader(r io.Reader, leafSize int, proofStart, proofEnd int, proof [][32]byte, root [32]byte) (*VerifyingReader, error) {
+	if err := checkRange(proofStart, proofEnd); err != nil {
+		return nil, err
+	}
+	start, end := uint64(proofStart), uint64(proofEnd)
+
+	// As in VerifyMultiRangeProof, manually build a tree using the proof
+	// hashes to the left of the range.
+	tree := New()
+	var leafIndex uint64
+	for leafIndex != start && len(proof) > 0 {
+		subtreeSize := nextSubtreeSize(leafIndex, start)
+		i := bits.TrailingZeros64(uint64(subtreeSize)) // log2
+		if err := tree.PushSubTree(i, proof[0]); err != nil {
+			return nil, err
+		}
+		proof = proof[1:]
+		leafIndex += uint64(subtreeSize)
+	}
+	if leafIndex != start {
+		return nil, ErrProofTooShort
+	}
+
+	return &VerifyingReader{
+		r:        r,
+		leafSize: leafSize,
+		start:    start,
+		end:      end,
+		tree:     tree,
+		proof:    proof,
+		root:     root,
+	}, nil
+}
+
+// Read implements io.Reader. It returns a *CorruptSubtreeError covering the
+// whole range if the data of the range does not match the root, and
+// io.ErrUnexpectedEOF if the underlying stream ends before the end of the
+// range.
+func (vr *VerifyingReader) Read(p []byte) (int, error) {
+	for len(vr.buf) == 0 {
+		if vr.err != nil {
+			return 0, vr.err
+		}
+		vr.err = vr.readRange()
+	}
+	n := copy(p, vr.buf)
+	vr.buf = vr.buf[n:]
+	return n, nil
+}
+
+// readRange reads the data of the range into vr.buf, hashing each leaf as
+// it is read, and checks the resulting root. It returns io.EOF once the data
+// has been returned.
+func (vr *VerifyingReader) readRange() error {
+	if vr.tree == nil {
+		return io.EOF
+	}
+	data := make([]byte, (vr.end-vr.start)*uint64(vr.leafSize))
+	var n int
+	for i := vr.start; i != vr.end; i++ {
+		m, err := io.ReadFull(vr.r, data[n:n+vr.leafSize])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A partial leaf is normal at the end of the stream, but only
+			// as the last leaf of the range.
+			if i != vr.end-1 || m == 0 {
+				return io.ErrUnexpectedEOF
+			}
+		} else if err != nil {
+			return err
+		}
+		vr.tree.Push(data[n : n+m])
+		n += m
+	}
+
+	// add the proof hashes to the right of the range
+	leafIndex := vr.end
+	for len(vr.proof) > 0 {
+		subtreeSize := nextSubtreeSize(leafIndex, math.MaxUint64)
+		i := bits.TrailingZeros64(uint64(subtreeSize)) // log2
+		if err := vr.tree.PushSubTree(i, vr.proof[0]); err != nil {
+			return err
+		}
+		vr.proof = vr.proof[1:]
+		leafIndex += uint64(subtreeSize)
+	}
+	ok := vr.tree.Root() == vr.root
+	vr.tree = nil
+	if !ok {
+		return &CorruptSubtreeError{Start: vr.start, End: vr.end}
+	}
+	vr.buf = data[:n]
+	return nil
+}
@@ -0,0 +1,108 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestMMR tests that the peaks and root of an MMR match those of a Tree, and
+// that proofs for every leaf can be verified.
+func TestMMR(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	data := fastrand.Bytes(70 * leafSize)
+	m := NewMMR(h)
+	if m.NumLeaves() != 0 || m.Peaks() != nil || m.Root() != nil {
+		t.Fatal("expected no peaks and a nil root for an empty MMR")
+	}
+	for numLeaves := uint64(1); numLeaves < 70; numLeaves++ {
+		m.Append(data[(numLeaves-1)*leafSize:][:leafSize])
+		if m.NumLeaves() != numLeaves {
+			t.Fatal("wrong number of leaves:", m.NumLeaves())
+		}
+
+		tree := New(h)
+		if err := tree.ReadAll(bytes.NewReader(data[:numLeaves*leafSize]), leafSize); err != nil {
+			t.Fatal(err)
+		}
+		peaks := m.Peaks()
+		if len(peaks) != len(tree.stack) {
+			t.Fatalf("MMR of %v leaves has %v peaks, expected %v", numLeaves, len(peaks), len(tree.stack))
+		}
+		for i := range peaks {
+			if !bytes.Equal(peaks[i], tree.stack[i].sum) {
+				t.Fatalf("MMR of %v leaves has the wrong peak %v", numLeaves, i)
+			}
+		}
+		if !bytes.Equal(m.Root(), tree.Root()) {
+			t.Fatalf("MMR of %v leaves has the wrong root", numLeaves)
+		}
+
+		for i := uint64(0); i < numLeaves; i++ {
+			leaf := data[i*leafSize:][:leafSize]
+			proof, err := m.Prove(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyMMRProof(h, peaks, numLeaves, i, leaf, proof) {
+				t.Fatalf("proof for leaf %v of %v was rejected", i, numLeaves)
+			} else if VerifyMMRProof(h, peaks, numLeaves, i, []byte("wrong"), proof) {
+				t.Fatal("proof verified with the wrong leaf data")
+			} else if i^1 < numLeaves && VerifyMMRProof(h, peaks, numLeaves, i^1, leaf, proof) {
+				t.Fatal("proof verified with the wrong index")
+			} else if len(proof) > 0 && VerifyMMRProof(h, peaks, numLeaves, i, leaf, append(proof, proof...)) {
+				t.Fatal("proof with extra hashes was accepted")
+			}
+		}
+	}
+	if _, err := m.Prove(m.NumLeaves()); err == nil {
+		t.Error("expected error when proving a leaf that is not in the MMR")
+	}
+	if _, err := m.ProveFrom(0, 10); err == nil {
+		t.Error("expected error when proving from above the peak")
+	}
+}
+
+// TestMMRProofGrowth tests that proofs remain valid as an MMR grows, and can
+// be brought up to date with ProveFrom once their peak has been merged.
+func TestMMRProofGrowth(t *testing.T) {
+	h := sha256.New()
+	m := NewMMR(h)
+	type oldProof struct {
+		index uint64
+		proof [][]byte
+	}
+	var proofs []oldProof
+	for numLeaves := uint64(1); numLeaves < 100; numLeaves++ {
+		m.Append([]byte{byte(numLeaves - 1)})
+		peaks := m.Peaks()
+		for i, p := range proofs {
+			leaf := []byte{byte(p.index)}
+			if VerifyMMRProof(h, peaks, numLeaves, p.index, leaf, p.proof) {
+				continue
+			}
+			// the peak of the proof must have been merged, in which case the
+			// top of the proof is all that is missing
+			height, _ := mmrPeak(numLeaves, p.index)
+			if len(p.proof) >= height {
+				t.Fatalf("proof for leaf %v became invalid after appending leaf %v", p.index, numLeaves-1)
+			}
+			ext, err := m.ProveFrom(p.index, len(p.proof))
+			if err != nil {
+				t.Fatal(err)
+			}
+			proofs[i].proof = append(p.proof, ext...)
+			if !VerifyMMRProof(h, peaks, numLeaves, p.index, leaf, proofs[i].proof) {
+				t.Fatalf("extended proof for leaf %v was rejected", p.index)
+			}
+		}
+		proof, err := m.Prove(numLeaves - 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, oldProof{numLeaves - 1, proof})
+	}
+}
@@ -0,0 +1,213 @@
+package merkletree
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// indexRanges converts a set of leaf indices, in any order and possibly
+// containing duplicates, into a sorted set of non-overlapping ranges.
+func indexRanges(indices []uint64) []LeafRange {
+	sorted := append([]uint64(nil), indices...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var ranges []LeafRange
+	for _, i := range sorted {
+		if len(ranges) > 0 && ranges[len(ranges)-1].End >= i {
+			if ranges[len(ranges)-1].End == i {
+				ranges[len(ranges)-1].End++
+			}
+			continue
+		}
+		ranges = append(ranges, LeafRange{i, i + 1})
+	}
+	return ranges
+}
+
+// SetRanges will tell the Tree to create a proof for the specified leaf
+// ranges, which must be sorted and non-overlapping. The proof is built while
+// the leaves are pushed, so that any number of ranges can be proven in a
+// single pass over the data, and is retrieved with ProveRanges. The Tree
+// keeps O(k log(n)) hashes for k ranges. SetRanges must be called on an empty
+// tree.
+func (t *Tree) SetRanges(ranges []LeafRange) error {
+	if len(t.stack) != 0 {
+		return errors.New("cannot call SetRanges on Tree if Tree has not been reset")
+	}
+	if len(ranges) == 0 || !validRangeSet(ranges) {
+		return errors.New("illegal set of proof ranges")
+	}
+	t.proofRanges = append([]LeafRange(nil), ranges...)
+	t.rangeIndex = 0
+	t.rangeLeaf = 0
+	t.rangeProof = nil
+	t.nextRangeProofSubTree()
+	return nil
+}
+
+// SetIndices is a convenience wrapper around SetRanges that proves the leaves
+// at the specified indices. The indices may be in any order. The proof
+// produced by ProveRanges is a proof for the ranges formed by the sorted
+// indices, with consecutive indices merged into a single range.
+func (t *Tree) SetIndices(indices []uint64) error {
+	return t.SetRanges(indexRanges(indices))
+}
+
+// nextRangeProofSubTree determines the next subtree whose root belongs in the
+// multi-range proof, skipping over any ranges that start at t.rangeLeaf. The
+// subtrees are the same subtrees that BuildMultiRangeProof consumes.
+func (t *Tree) nextRangeProofSubTree() {
+	for t.rangeIndex < len(t.proofRanges) && t.rangeLeaf == t.proofRanges[t.rangeIndex].Start {
+		t.rangeLeaf = t.proofRanges[t.rangeIndex].End
+		t.rangeIndex++
+	}
+	end := uint64(math.MaxUint64)
+	if t.rangeIndex < len(t.proofRanges) {
+		end = t.proofRanges[t.rangeIndex].Start
+	}
+	t.rangeHeight = bits.TrailingZeros64(uint64(nextSubtreeSize(t.rangeLeaf, end)))
+}
+
+// addRangeProofNode is called whenever the Tree creates a new subtree, i.e.
+// when a leaf or subtree is pushed and when two subtrees are joined. If the
+// subtree is the next subtree of the multi-range proof, its sum is added to
+// the proof. Since the subtrees of the proof are disjoint, they are created in
+// the same order in which they appear in the proof.
+func (t *Tree) addRangeProofNode(start uint64, height int, sum []byte) {
+	if t.proofRanges == nil || start != t.rangeLeaf || height != t.rangeHeight {
+		return
+	}
+	// The sum is copied, because the buffer is reused as more leaves are
+	// pushed.
+	t.rangeProof = append(t.rangeProof, append([]byte(nil), sum...))
+	t.rangeLeaf += 1 << uint(height)
+	t.nextRangeProofSubTree()
+}
+
+// canPushRangeSubTree reports whether the subtree containing the leaves
+// [start, end) can be pushed using PushSubTree without preventing the Tree
+// from building the multi-range proof. Every subtree that lies between two
+// ranges is contained in one of the subtrees of the proof, so the only
+// subtrees that can't be pushed are those that partially overlap a range.
+func (t *Tree) canPushRangeSubTree(start, end uint64) bool {
+	for _, r := range t.proofRanges {
+		if start < r.End && r.Start < end && (start < r.Start || r.End < end) {
+			return false
+		}
+	}
+	return true
+}
+
+// ProveRanges creates a proof that the leaves within the ranges established
+// by SetRanges are elements of the Merkle tree. The proof is identical to the
+// proof produced by BuildMultiRangeProof, and can be verified with
+// VerifyMultiRangeProof. ProveRanges will return a nil proof if the Tree does
+// not yet contain all of the ranges. ProveRanges does not modify the Tree.
+// ProveRanges can only be called if SetRanges or SetIndices has been called
+// previously.
+func (t *Tree) ProveRanges() (merkleRoot []byte, proof [][]byte, numLeaves uint64) {
+	if t.proofRanges == nil {
+		panic("wrong usage: can't call ProveRanges on a tree if SetRanges wasn't called")
+	}
+	if t.currentIndex < t.proofRanges[len(t.proofRanges)-1].End {
+		return t.Root(), nil, t.currentIndex
+	}
+	proof = make([][]byte, len(t.rangeProof), len(t.rangeProof)+1)
+	copy(proof, t.rangeProof)
+
+	// The proof ends with the root of the leaves after the last complete
+	// subtree of the proof. Those leaves are exactly the leaves of the
+	// smallest subtrees on the stack.
+	if t.rangeLeaf < t.currentIndex {
+		start := t.currentIndex
+		var root []byte
+		for i := len(t.stack) - 1; i >= 0 && start > t.rangeLeaf; i-- {
+			start -= 1 << uint(t.stack[i].height)
+			if root == nil {
+				root = append([]byte(nil), t.stack[i].sum...)
+			} else {
+				root = nodeSum(t.hash, t.stack[i].sum, root)
+			}
+		}
+		if DEBUG {
+			if start != t.rangeLeaf {
+				panic("remaining leaves do not match the subtrees on the stack")
+			}
+		}
+		proof = append(proof, root)
+	}
+	return t.Root(), proof, t.currentIndex
+}
+
+// SetRanges will inform the CachedTree of the leaf ranges for which a proof
+// is being created. The ranges should be ranges of actual leaves, and not of
+// the cached elements containing the leaves. SetRanges must be called on an
+// empty CachedTree.
+func (ct *CachedTree) SetRanges(ranges []LeafRange) error {
+	if len(ranges) == 0 || !validRangeSet(ranges) {
+		return errors.New("illegal set of proof ranges")
+	}
+	// Convert the ranges into ranges of the cached elements that contain
+	// them, merging ranges that touch the same element.
+	var cachedRanges []LeafRange
+	for _, r := range ranges {
+		start := r.Start >> ct.cachedNodeHeight
+		end := (r.End-1)>>ct.cachedNodeHeight + 1
+		if len(cachedRanges) > 0 && cachedRanges[len(cachedRanges)-1].End >= start {
+			cachedRanges[len(cachedRanges)-1].End = end
+			continue
+		}
+		cachedRanges = append(cachedRanges, LeafRange{start, end})
+	}
+	return ct.Tree.SetRanges(cachedRanges)
+}
+
+// SetIndices is a convenience wrapper around SetRanges that proves the leaves
+// at the specified indices, in the same way as (*Tree).SetIndices.
+func (ct *CachedTree) SetIndices(indices []uint64) error {
+	return ct.SetRanges(indexRanges(indices))
+}
+
+// ProveRanges will create a proof that the leaves within the ranges
+// established by SetRanges are a part of the data represented by the Merkle
+// root of the CachedTree. The CachedTree needs a proof for each of the cached
+// elements that contain leaves of the ranges, in order. Each proof is the
+// proof for the parts of the ranges that fall within the cached element,
+// relative to the first leaf of the element, as produced by
+// BuildMultiRangeProof or (*Tree).ProveRanges over the leaves of the element.
+// If the ranges cover an element completely, its proof is empty.
+//
+// The resulting proof can be verified with VerifyMultiRangeProof. ProveRanges
+// will return a nil proof if the CachedTree does not yet contain all of the
+// ranges or if the number of cached proofs is wrong.
+func (ct *CachedTree) ProveRanges(cachedProofs [][][]byte) (merkleRoot []byte, proof [][]byte, numLeaves uint64) {
+	merkleRoot, treeProof, numCached := ct.Tree.ProveRanges()
+	numLeaves = numCached << ct.cachedNodeHeight
+	var numElements uint64
+	for _, r := range ct.proofRanges {
+		numElements += r.End - r.Start
+	}
+	if treeProof == nil || uint64(len(cachedProofs)) != numElements {
+		return merkleRoot, nil, numLeaves
+	}
+
+	// The proof of the Tree contains the subtrees between the cached
+	// elements that contain the ranges. Insert the proofs of those elements
+	// in between.
+	proof = [][]byte{}
+	var index uint64
+	for _, r := range ct.proofRanges {
+		for index != r.Start {
+			proof = append(proof, treeProof[0])
+			treeProof = treeProof[1:]
+			index += uint64(nextSubtreeSize(index, r.Start))
+		}
+		for ; index != r.End; index++ {
+			proof = append(proof, cachedProofs[0]...)
+			cachedProofs = cachedProofs[1:]
+		}
+	}
+	proof = append(proof, treeProof...)
+	return merkleRoot, proof, numLeaves
+}
@@ -0,0 +1,227 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// randomRanges returns a random, non-empty set of sorted, non-overlapping
+// ranges within [0, numLeaves).
+func randomRanges(numLeaves uint64) []LeafRange {
+	var ranges []LeafRange
+	for len(ranges) == 0 {
+		for start := uint64(fastrand.Intn(3)); start < numLeaves; {
+			end := start + 1 + uint64(fastrand.Intn(4))
+			if end > numLeaves {
+				end = numLeaves
+			}
+			ranges = append(ranges, LeafRange{start, end})
+			start = end + uint64(fastrand.Intn(int(numLeaves)))
+		}
+	}
+	return ranges
+}
+
+// TestTreeProveRanges tests that the multi-range proofs produced by a Tree are
+// identical to the proofs produced by BuildMultiRangeProof.
+func TestTreeProveRanges(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	for numLeaves := uint64(1); numLeaves < 70; numLeaves++ {
+		data := fastrand.Bytes(int(numLeaves) * leafSize)
+		root := bytesRoot(data, h, leafSize)
+		for n := 0; n < 10; n++ {
+			ranges := randomRanges(numLeaves)
+			tree := New(h)
+			if err := tree.SetRanges(ranges); err != nil {
+				t.Fatal(err)
+			}
+			for i := uint64(0); i < numLeaves; i++ {
+				if i < ranges[len(ranges)-1].End {
+					if _, proof, _ := tree.ProveRanges(); proof != nil {
+						t.Fatal("ProveRanges should return a nil proof before all ranges are pushed")
+					}
+				}
+				tree.Push(data[i*leafSize:][:leafSize])
+			}
+			merkleRoot, proof, leaves := tree.ProveRanges()
+			if !bytes.Equal(merkleRoot, root) || leaves != numLeaves {
+				t.Fatal("ProveRanges returned wrong root or number of leaves")
+			}
+			expProof, err := BuildMultiRangeProof(ranges, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, h))
+			if err != nil {
+				t.Fatal(err)
+			} else if len(proof) != len(expProof) || (len(proof) != 0 && !reflect.DeepEqual(proof, expProof)) {
+				t.Fatalf("wrong proof for %v leaves, ranges %v", numLeaves, ranges)
+			}
+
+			var rangeData []byte
+			for _, r := range ranges {
+				rangeData = append(rangeData, data[r.Start*leafSize:r.End*leafSize]...)
+			}
+			lh := NewReaderLeafHasher(bytes.NewReader(rangeData), h, leafSize)
+			if ok, err := VerifyMultiRangeProof(lh, h, ranges, proof, root); !ok || err != nil {
+				t.Fatalf("proof for %v leaves, ranges %v failed to verify: %v", numLeaves, ranges, err)
+			}
+		}
+	}
+}
+
+// TestTreeProveRangesSubTrees tests that subtrees can be pushed into a Tree
+// building a multi-range proof, as long as they don't partially overlap a
+// range.
+func TestTreeProveRangesSubTrees(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	data := fastrand.Bytes(32 * leafSize)
+	ranges := []LeafRange{{5, 7}, {16, 24}}
+	subtreeRoot := func(start, end uint64) []byte {
+		return bytesRoot(data[start*leafSize:end*leafSize], h, leafSize)
+	}
+
+	tree := New(h)
+	if err := tree.SetRanges(ranges); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PushSubTree(3, subtreeRoot(0, 8)); err == nil {
+		t.Fatal("expected error when pushing a subtree that partially overlaps a range")
+	}
+	for _, st := range []struct {
+		height     int
+		start, end uint64
+	}{
+		{2, 0, 4},   // before the first range
+		{0, 4, 5},   // part of a proof subtree
+		{0, 5, 6},   // within a range
+		{0, 6, 7},   // within a range
+		{0, 7, 8},   // a proof subtree
+		{3, 8, 16},  // a proof subtree
+		{3, 16, 24}, // exactly a range
+		{2, 24, 28}, // part of a proof subtree
+		{1, 28, 30}, // part of the final subtree
+	} {
+		if err := tree.PushSubTree(st.height, subtreeRoot(st.start, st.end)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, proof, _ := tree.ProveRanges()
+	expProof, err := BuildMultiRangeProof(ranges, NewReaderSubtreeHasher(bytes.NewReader(data[:30*leafSize]), leafSize, h))
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(proof, expProof) {
+		t.Fatal("tree built from subtrees produced wrong proof")
+	}
+}
+
+// TestSetIndices tests that SetIndices proves the ranges formed by the sorted
+// indices.
+func TestSetIndices(t *testing.T) {
+	indices := []uint64{9, 3, 4, 3, 12, 10, 5, 0}
+	expRanges := []LeafRange{{0, 1}, {3, 6}, {9, 11}, {12, 13}}
+	if ranges := indexRanges(indices); !reflect.DeepEqual(ranges, expRanges) {
+		t.Fatalf("expected ranges %v, got %v", expRanges, ranges)
+	}
+
+	h := sha256.New()
+	data := fastrand.Bytes(20 * 8)
+	tree := New(h)
+	if err := tree.SetIndices(indices); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ReadAll(bytes.NewReader(data), 8); err != nil {
+		t.Fatal(err)
+	}
+	_, proof, _ := tree.ProveRanges()
+	expProof, _ := BuildMultiRangeProof(expRanges, NewReaderSubtreeHasher(bytes.NewReader(data), 8, h))
+	if !reflect.DeepEqual(proof, expProof) {
+		t.Fatal("SetIndices produced wrong proof")
+	}
+
+	if err := New(h).SetIndices(nil); err == nil {
+		t.Fatal("expected error when setting no indices")
+	}
+	if err := New(h).SetRanges([]LeafRange{{3, 5}, {4, 6}}); err == nil {
+		t.Fatal("expected error when setting overlapping ranges")
+	}
+	if err := tree.SetIndices([]uint64{1}); err == nil {
+		t.Fatal("expected error when calling SetIndices on a non-empty tree")
+	}
+}
+
+// TestCachedTreeProveRanges tests that a CachedTree produces the same
+// multi-range proofs as BuildMultiRangeProof.
+func TestCachedTreeProveRanges(t *testing.T) {
+	const leafSize = 8
+	const cachedNodeHeight = 2
+	const leavesPerNode = 1 << cachedNodeHeight
+	h := sha256.New()
+	for numNodes := uint64(1); numNodes < 12; numNodes++ {
+		numLeaves := numNodes * leavesPerNode
+		data := fastrand.Bytes(int(numLeaves) * leafSize)
+		for n := 0; n < 10; n++ {
+			ranges := randomRanges(numLeaves)
+			tree := NewCachedTree(h, cachedNodeHeight)
+			if err := tree.SetRanges(ranges); err != nil {
+				t.Fatal(err)
+			}
+
+			// build the proofs of the cached elements that contain the
+			// ranges
+			var cachedProofs [][][]byte
+			for node := uint64(0); node < numNodes; node++ {
+				nodeStart, nodeEnd := node*leavesPerNode, (node+1)*leavesPerNode
+				nodeData := data[nodeStart*leafSize : nodeEnd*leafSize]
+				tree.Push(bytesRoot(nodeData, h, leafSize))
+				var nodeRanges []LeafRange
+				for _, r := range ranges {
+					if r.Start < nodeEnd && nodeStart < r.End {
+						start, end := r.Start, r.End
+						if start < nodeStart {
+							start = nodeStart
+						}
+						if end > nodeEnd {
+							end = nodeEnd
+						}
+						nodeRanges = append(nodeRanges, LeafRange{start - nodeStart, end - nodeStart})
+					}
+				}
+				if len(nodeRanges) == 0 {
+					continue
+				}
+				proof, err := BuildMultiRangeProof(nodeRanges, NewReaderSubtreeHasher(bytes.NewReader(nodeData), leafSize, h))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cachedProofs = append(cachedProofs, proof)
+			}
+
+			merkleRoot, proof, leaves := tree.ProveRanges(cachedProofs)
+			if leaves != numLeaves {
+				t.Fatalf("expected %v leaves, got %v", numLeaves, leaves)
+			}
+			expProof, err := BuildMultiRangeProof(ranges, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, h))
+			if err != nil {
+				t.Fatal(err)
+			} else if len(proof) != len(expProof) || (len(proof) != 0 && !reflect.DeepEqual(proof, expProof)) {
+				t.Fatalf("wrong proof for %v leaves, ranges %v", numLeaves, ranges)
+			}
+			var rangeData []byte
+			for _, r := range ranges {
+				rangeData = append(rangeData, data[r.Start*leafSize:r.End*leafSize]...)
+			}
+			lh := NewReaderLeafHasher(bytes.NewReader(rangeData), h, leafSize)
+			if ok, err := VerifyMultiRangeProof(lh, h, ranges, proof, merkleRoot); !ok || err != nil {
+				t.Fatalf("proof for %v leaves, ranges %v failed to verify: %v", numLeaves, ranges, err)
+			}
+
+			// the wrong number of cached proofs should produce a nil proof
+			if _, proof, _ := tree.ProveRanges(cachedProofs[1:]); proof != nil {
+				t.Fatal("expected nil proof when cached proofs are missing")
+			}
+		}
+	}
+}
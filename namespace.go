@@ -0,0 +1,267 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"sort"
+)
+
+// A namespacedHash wraps a hash.Hash so that every leaf and node hash of a
+// Merkle tree also commits to the range of namespaces in its subtree. The
+// hash of a leaf or node is
+//
+//	minNamespace || maxNamespace || Hash(input)
+//
+// where the namespace of a leaf is the first namespaceSize bytes of its data,
+// and the namespace range of a node spans the ranges of both of its
+// children. Since the namespace range is part of the hash, it is committed
+// to by every parent, so a verifier can trust the namespace range of any
+// hash that is part of a valid proof.
+type namespacedHash struct {
+	hash          hash.Hash
+	namespaceSize int
+	buf           []byte
+}
+
+// NewNamespacedHash returns a hash.Hash that produces the hashes of a
+// namespaced Merkle tree when used in place of h, e.g. with a Tree or with
+// BuildMultiRangeProof. The data of each leaf must begin with its namespace,
+// which is namespaceSize bytes long, and the leaves of the tree must be
+// sorted by namespace. The hashes are 2*namespaceSize bytes longer than those
+// of h.
+func NewNamespacedHash(h hash.Hash, namespaceSize int) hash.Hash {
+	return &namespacedHash{
+		hash:          h,
+		namespaceSize: namespaceSize,
+	}
+}
+
+// Write implements hash.Hash.
+func (nh *namespacedHash) Write(p []byte) (int, error) {
+	nh.buf = append(nh.buf, p...)
+	return len(p), nil
+}
+
+// Sum implements hash.Hash.
+func (nh *namespacedHash) Sum(b []byte) []byte {
+	min, max := nh.namespaceRange()
+	b = append(b, min...)
+	b = append(b, max...)
+	nh.hash.Reset()
+	// the Hash interface specifies that Write never returns an error
+	_, _ = nh.hash.Write(nh.buf)
+	return nh.hash.Sum(b)
+}
+
+// Reset implements hash.Hash.
+func (nh *namespacedHash) Reset() {
+	nh.buf = nh.buf[:0]
+}
+
+// Size implements hash.Hash.
+func (nh *namespacedHash) Size() int {
+	return 2*nh.namespaceSize + nh.hash.Size()
+}
+
+// BlockSize implements hash.Hash.
+func (nh *namespacedHash) BlockSize() int {
+	return nh.hash.BlockSize()
+}
+
+// namespaceRange returns the range of namespaces covered by the leaf or node
+// whose hash input has been written. Input that is neither a leaf nor a node
+// covers the all-zero namespace.
+func (nh *namespacedHash) namespaceRange() (min, max []byte) {
+	ns, size := nh.namespaceSize, nh.Size()
+	switch {
+	case len(nh.buf) == 1+2*size && nh.buf[0] == nodeHashPrefix[0]:
+		left, right := nh.buf[1:1+size], nh.buf[1+size:]
+		min, max = left[:ns], left[ns:2*ns]
+		if bytes.Compare(right[:ns], min) < 0 {
+			min = right[:ns]
+		}
+		if bytes.Compare(right[ns:2*ns], max) > 0 {
+			max = right[ns : 2*ns]
+		}
+		return min, max
+	case len(nh.buf) >= 1+ns && nh.buf[0] == leafHashPrefix[0]:
+		return nh.buf[1 : 1+ns], nh.buf[1 : 1+ns]
+	default:
+		zero := make([]byte, ns)
+		return zero, zero
+	}
+}
+
+// splitNamespaces returns the namespace range of a namespaced hash.
+func splitNamespaces(sum []byte, namespaceSize int) (min, max []byte) {
+	return sum[:namespaceSize], sum[namespaceSize : 2*namespaceSize]
+}
+
+// A NamespacedTree is a Merkle tree whose leaves are tagged with namespaces,
+// and whose hashes commit to the namespaces of their subtrees. This makes it
+// possible to prove that a set of leaves is the complete set of leaves of a
+// namespace, or that the tree contains no leaves of a namespace.
+type NamespacedTree struct {
+	hash          hash.Hash
+	namespaceSize int
+	tree          *Tree
+	leafHashes    [][]byte
+	namespaces    [][]byte
+}
+
+// NewNamespacedTree returns an empty NamespacedTree that uses h for all
+// hashing operations, and whose namespaces are namespaceSize bytes long.
+func NewNamespacedTree(h hash.Hash, namespaceSize int) *NamespacedTree {
+	nh := NewNamespacedHash(h, namespaceSize)
+	return &NamespacedTree{
+		hash:          nh,
+		namespaceSize: namespaceSize,
+		tree:          New(nh),
+	}
+}
+
+// Push adds a leaf with the specified namespace and data to the tree. Leaves
+// must be pushed in order of their namespaces.
+func (t *NamespacedTree) Push(namespace, data []byte) error {
+	if len(namespace) != t.namespaceSize {
+		return errors.New("namespace has the wrong size")
+	} else if len(t.namespaces) > 0 && bytes.Compare(namespace, t.namespaces[len(t.namespaces)-1]) < 0 {
+		return errors.New("leaves must be pushed in order of their namespaces")
+	}
+	leafHash := sum(t.hash, leafHashPrefix, namespace, data)
+	if err := t.tree.PushSubTree(0, leafHash); err != nil {
+		return err
+	}
+	t.leafHashes = append(t.leafHashes, leafHash)
+	t.namespaces = append(t.namespaces, append([]byte(nil), namespace...))
+	return nil
+}
+
+// NumLeaves returns the number of leaves in the tree.
+func (t *NamespacedTree) NumLeaves() uint64 {
+	return uint64(len(t.leafHashes))
+}
+
+// Root returns the Merkle root of the tree, which begins with the range of
+// namespaces in the tree. It returns nil if the tree is empty.
+func (t *NamespacedTree) Root() []byte {
+	return t.tree.Root()
+}
+
+// A NamespaceProof proves that a set of leaves is the complete set of leaves
+// of a namespace in a NamespacedTree, or that the tree contains no leaves of
+// the namespace.
+type NamespaceProof struct {
+	// Range holds the leaves of the namespace. If the namespace is not in the
+	// tree, it holds a single leaf next to the position of the namespace.
+	Range     LeafRange
+	NumLeaves uint64
+	Hashes    [][]byte
+
+	// LeafHash is the hash of the leaf in Range if the namespace is not in
+	// the tree, and nil otherwise.
+	LeafHash []byte
+}
+
+// ProveNamespace returns a proof for the leaves of the specified namespace,
+// which can be verified with VerifyNamespace.
+func (t *NamespacedTree) ProveNamespace(namespace []byte) (NamespaceProof, error) {
+	if len(namespace) != t.namespaceSize {
+		return NamespaceProof{}, errors.New("namespace has the wrong size")
+	}
+	n := len(t.namespaces)
+	if n == 0 {
+		return NamespaceProof{}, nil
+	}
+	start := sort.Search(n, func(i int) bool {
+		return bytes.Compare(t.namespaces[i], namespace) >= 0
+	})
+	end := sort.Search(n, func(i int) bool {
+		return bytes.Compare(t.namespaces[i], namespace) > 0
+	})
+
+	var proof NamespaceProof
+	if start == end {
+		// Prove the leaf that follows the position of the namespace, or the
+		// last leaf if the namespace would come after all of the leaves.
+		if start == n {
+			start--
+		}
+		end = start + 1
+		proof.LeafHash = append([]byte(nil), t.leafHashes[start]...)
+	}
+	proof.Range = LeafRange{Start: uint64(start), End: uint64(end)}
+	proof.NumLeaves = uint64(n)
+
+	sh := NewCachedSubtreeHasher(t.leafHashes, t.hash)
+	hashes, err := BuildMultiRangeProofWithSize([]LeafRange{proof.Range}, sh, proof.NumLeaves)
+	if err != nil {
+		return NamespaceProof{}, err
+	}
+	proof.Hashes = hashes
+	return proof, nil
+}
+
+// VerifyNamespace verifies a proof produced by ProveNamespace, showing that
+// the leaves hold the data of all of the leaves of the namespace, in order,
+// in the NamespacedTree with the specified root. If the namespace is not in
+// the tree, leaves must be empty. h is the hash that was passed to
+// NewNamespacedTree.
+func VerifyNamespace(h hash.Hash, namespace []byte, leaves [][]byte, proof NamespaceProof, root []byte) bool {
+	if proof.NumLeaves == 0 {
+		return len(leaves) == 0 && len(proof.Hashes) == 0 && proof.LeafHash == nil && len(root) == 0
+	}
+	nh := NewNamespacedHash(h, len(namespace))
+	r := proof.Range
+	if r.Start >= r.End {
+		return false
+	}
+
+	// Compute the hashes of the leaves in the proof range. If the namespace
+	// is absent, the single leaf in the range must belong to another
+	// namespace.
+	var leafHashes [][]byte
+	if len(leaves) == 0 {
+		if r.End-r.Start != 1 || len(proof.LeafHash) != nh.Size() {
+			return false
+		}
+		min, _ := splitNamespaces(proof.LeafHash, len(namespace))
+		if bytes.Equal(min, namespace) {
+			return false
+		}
+		leafHashes = [][]byte{proof.LeafHash}
+	} else {
+		if r.End-r.Start != uint64(len(leaves)) || proof.LeafHash != nil {
+			return false
+		}
+		for _, leaf := range leaves {
+			leafHashes = append(leafHashes, sum(nh, leafHashPrefix, namespace, leaf))
+		}
+	}
+
+	// Every hash to the left of the range must cover only smaller
+	// namespaces, and every hash to the right only larger namespaces, so
+	// that no leaf of the namespace can be outside of the range.
+	var numLeft int
+	for i := uint64(0); i < r.Start; i += uint64(nextSubtreeSize(i, r.Start)) {
+		numLeft++
+	}
+	if numLeft > len(proof.Hashes) {
+		return false
+	}
+	for i, proofHash := range proof.Hashes {
+		if len(proofHash) != nh.Size() {
+			return false
+		}
+		min, max := splitNamespaces(proofHash, len(namespace))
+		if i < numLeft && bytes.Compare(max, namespace) >= 0 {
+			return false
+		} else if i >= numLeft && bytes.Compare(min, namespace) <= 0 {
+			return false
+		}
+	}
+
+	ok, err := VerifyMultiRangeProofWithSize(NewCachedLeafHasher(leafHashes), nh, []LeafRange{r}, proof.Hashes, root, proof.NumLeaves)
+	return ok && err == nil
+}
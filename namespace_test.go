@@ -0,0 +1,122 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// namespaceID returns an 8-byte namespace.
+func namespaceID(n uint64) []byte {
+	ns := make([]byte, 8)
+	binary.BigEndian.PutUint64(ns, n)
+	return ns
+}
+
+// TestNamespacedTree tests that namespace proofs can be built and verified
+// for namespaces that are present in the tree and for namespaces that are
+// not.
+func TestNamespacedTree(t *testing.T) {
+	h := sha256.New()
+	for _, counts := range [][]int{
+		{1},
+		{0, 3},
+		{2, 0, 1, 5, 0, 0, 4},
+		{0, 1, 1, 1, 0, 7, 2, 0, 1},
+	} {
+		tree := NewNamespacedTree(h, 8)
+		plain := New(NewNamespacedHash(h, 8))
+		leaves := make([][][]byte, len(counts)+1)
+		for n, count := range counts {
+			ns := namespaceID(uint64(n))
+			for i := 0; i < count; i++ {
+				data := fastrand.Bytes(16)
+				leaves[n] = append(leaves[n], data)
+				if err := tree.Push(ns, data); err != nil {
+					t.Fatal(err)
+				}
+				plain.Push(append(ns, data...))
+			}
+		}
+		root := tree.Root()
+		if !bytes.Equal(root, plain.Root()) {
+			t.Fatal("NamespacedTree root does not match the Tree root")
+		}
+
+		for n := range leaves {
+			ns := namespaceID(uint64(n))
+			proof, err := tree.ProveNamespace(ns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyNamespace(h, ns, leaves[n], proof, root) {
+				t.Fatalf("valid proof for namespace %v was rejected", n)
+			}
+			if len(leaves[n]) == 0 {
+				if proof.LeafHash == nil {
+					t.Fatal("expected an absence proof")
+				}
+				continue
+			}
+			// omitting a leaf of the namespace must not verify, even with a
+			// proof for the remaining leaves
+			if len(leaves[n]) > 1 {
+				partial := proof
+				partial.Range.End--
+				partial.Hashes, err = BuildMultiRangeProofWithSize([]LeafRange{partial.Range}, NewCachedSubtreeHasher(tree.leafHashes, tree.hash), tree.NumLeaves())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if VerifyNamespace(h, ns, leaves[n][:len(leaves[n])-1], partial, root) {
+					t.Fatal("proof for an incomplete set of leaves was accepted")
+				}
+			}
+			if VerifyNamespace(h, ns, append(leaves[n][:len(leaves[n]):len(leaves[n])], []byte("foo")), proof, root) {
+				t.Fatal("proof with an extra leaf was accepted")
+			}
+			// an absence proof cannot be made for a namespace in the tree
+			absent := proof
+			absent.Range.End = absent.Range.Start + 1
+			absent.LeafHash = tree.leafHashes[absent.Range.Start]
+			absent.Hashes, err = BuildMultiRangeProofWithSize([]LeafRange{absent.Range}, NewCachedSubtreeHasher(tree.leafHashes, tree.hash), tree.NumLeaves())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if VerifyNamespace(h, ns, nil, absent, root) {
+				t.Fatal("absence proof was accepted for a namespace in the tree")
+			}
+		}
+	}
+}
+
+// TestNamespacedTreeErrors tests that a NamespacedTree rejects malformed
+// namespaces, and that an empty tree proves every namespace absent.
+func TestNamespacedTreeErrors(t *testing.T) {
+	h := sha256.New()
+	tree := NewNamespacedTree(h, 8)
+	proof, err := tree.ProveNamespace(namespaceID(3))
+	if err != nil {
+		t.Fatal(err)
+	} else if !VerifyNamespace(h, namespaceID(3), nil, proof, tree.Root()) {
+		t.Fatal("absence proof for an empty tree was rejected")
+	}
+
+	if err := tree.Push([]byte{1}, nil); err == nil {
+		t.Error("expected error when pushing a namespace of the wrong size")
+	}
+	if err := tree.Push(namespaceID(2), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Push(namespaceID(1), nil); err == nil {
+		t.Error("expected error when pushing namespaces out of order")
+	}
+	if _, err := tree.ProveNamespace([]byte{1}); err == nil {
+		t.Error("expected error when proving a namespace of the wrong size")
+	}
+	if VerifyNamespace(h, namespaceID(3), nil, proof, tree.Root()) {
+		t.Error("empty proof was accepted for a non-empty tree")
+	}
+}
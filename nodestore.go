@@ -0,0 +1,120 @@
+package merkletree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// A NodeStore stores the node hashes of a Merkle tree. Nodes are addressed by
+// their height and index: the node at (height, index) is the root of the
+// complete subtree containing the leaves [index * 2^height, (index+1) *
+// 2^height). The leaf hashes are therefore the nodes of height 0.
+type NodeStore interface {
+	// Node returns the hash of the node at (height, index). The returned
+	// slice must not be modified.
+	Node(height int, index uint64) ([]byte, error)
+	// PutNode stores the hash of the node at (height, index).
+	PutNode(height int, index uint64, sum []byte) error
+}
+
+// MemoryNodeStore implements NodeStore by keeping every node hash in memory.
+// Nodes must be stored from left to right within each height.
+type MemoryNodeStore struct {
+	levels [][][]byte
+}
+
+// Node implements NodeStore.
+func (ms *MemoryNodeStore) Node(height int, index uint64) ([]byte, error) {
+	if height >= len(ms.levels) || index >= uint64(len(ms.levels[height])) {
+		return nil, fmt.Errorf("node (%v, %v) is not in the store", height, index)
+	}
+	return ms.levels[height][index], nil
+}
+
+// PutNode implements NodeStore.
+func (ms *MemoryNodeStore) PutNode(height int, index uint64, sum []byte) error {
+	for height >= len(ms.levels) {
+		ms.levels = append(ms.levels, nil)
+	}
+	level := ms.levels[height]
+	if index > uint64(len(level)) {
+		return errors.New("nodes must be stored in order")
+	}
+	sum = append([]byte(nil), sum...)
+	if index == uint64(len(level)) {
+		ms.levels[height] = append(level, sum)
+	} else {
+		level[index] = sum
+	}
+	return nil
+}
+
+// NewMemoryNodeStore returns an empty MemoryNodeStore.
+func NewMemoryNodeStore() *MemoryNodeStore {
+	return &MemoryNodeStore{}
+}
+
+// OutboardNodeStore implements NodeStore on top of a file (or any other
+// io.ReaderAt and io.WriterAt), so that the hash tree of a file can be kept
+// next to the data. Node hashes are stored in post-order, i.e. each node
+// immediately follows the last node of its right child. This means that a
+// tree is written strictly sequentially as its leaves are pushed, and that
+// appending leaves to the tree only ever appends to the file.
+type OutboardNodeStore struct {
+	f interface {
+		io.ReaderAt
+		io.WriterAt
+	}
+	hashSize int
+}
+
+// outboardOffset returns the offset of the node at (height, index) within an
+// OutboardNodeStore, in units of hashes.
+func outboardOffset(height int, index uint64) uint64 {
+	// The node completes when the last of its leaves is pushed. At that
+	// point, a tree of n leaves contains 2n - popcount(n) complete nodes,
+	// the last trailingZeros(n) of which are the ancestors of the last leaf
+	// that sit above this node.
+	n := (index + 1) << uint(height)
+	total := 2*n - uint64(bits.OnesCount64(n))
+	return total - 1 - uint64(bits.TrailingZeros64(n)-height)
+}
+
+// Node implements NodeStore.
+func (obs *OutboardNodeStore) Node(height int, index uint64) ([]byte, error) {
+	sum := make([]byte, obs.hashSize)
+	off := int64(outboardOffset(height, index)) * int64(obs.hashSize)
+	n, err := obs.f.ReadAt(sum, off)
+	if n == len(sum) {
+		// ReadAt may return io.EOF along with the last bytes of the file.
+		return sum, nil
+	} else if err == io.EOF {
+		return nil, fmt.Errorf("node (%v, %v) is not in the store", height, index)
+	}
+	return nil, err
+}
+
+// PutNode implements NodeStore.
+func (obs *OutboardNodeStore) PutNode(height int, index uint64, sum []byte) error {
+	if len(sum) != obs.hashSize {
+		return fmt.Errorf("node hash has size %v, expected %v", len(sum), obs.hashSize)
+	}
+	off := int64(outboardOffset(height, index)) * int64(obs.hashSize)
+	_, err := obs.f.WriteAt(sum, off)
+	return err
+}
+
+// NewOutboardNodeStore returns an OutboardNodeStore that stores hashes of
+// size hashSize in f. f may already contain the nodes of a tree, e.g. when
+// reopening an outboard file; see NewStoredFullTree.
+func NewOutboardNodeStore(f interface {
+	io.ReaderAt
+	io.WriterAt
+}, hashSize int) *OutboardNodeStore {
+	return &OutboardNodeStore{
+		f:        f,
+		hashSize: hashSize,
+	}
+}
@@ -0,0 +1,139 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// A recordingNodeStore wraps a NodeStore and records the order in which nodes
+// are stored.
+type recordingNodeStore struct {
+	NodeStore
+	puts [][2]uint64
+}
+
+func (rs *recordingNodeStore) PutNode(height int, index uint64, sum []byte) error {
+	rs.puts = append(rs.puts, [2]uint64{uint64(height), index})
+	return rs.NodeStore.PutNode(height, index, sum)
+}
+
+// TestOutboardOffset tests that outboardOffset matches the order in which a
+// FullTree stores its nodes.
+func TestOutboardOffset(t *testing.T) {
+	rs := &recordingNodeStore{NodeStore: NewMemoryNodeStore()}
+	ft := NewStoredFullTree(sha256.New(), rs, 0)
+	for i := 0; i < 300; i++ {
+		if err := ft.Push([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, p := range rs.puts {
+		if off := outboardOffset(int(p[0]), p[1]); off != uint64(i) {
+			t.Fatalf("node (%v, %v) was stored at position %v, but has offset %v", p[0], p[1], i, off)
+		}
+	}
+}
+
+// TestMemoryNodeStore tests the MemoryNodeStore.
+func TestMemoryNodeStore(t *testing.T) {
+	ms := NewMemoryNodeStore()
+	if _, err := ms.Node(0, 0); err == nil {
+		t.Fatal("expected error when getting missing node")
+	}
+	if err := ms.PutNode(1, 1, []byte{1}); err == nil {
+		t.Fatal("expected error when storing nodes out of order")
+	}
+	sum := []byte{1, 2, 3}
+	if err := ms.PutNode(1, 0, sum); err != nil {
+		t.Fatal(err)
+	}
+	sum[0] = 0
+	if node, err := ms.Node(1, 0); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(node, []byte{1, 2, 3}) {
+		t.Fatal("store did not copy the node hash")
+	}
+}
+
+// TestOutboardNodeStore tests that a FullTree backed by an OutboardNodeStore
+// can be reopened and used to build proofs without rehashing the data.
+func TestOutboardNodeStore(t *testing.T) {
+	const leafSize = 64
+	const numLeaves = 100
+	h := sha256.New()
+	data := fastrand.Bytes(leafSize * numLeaves)
+
+	dir, err := ioutil.TempDir("", "merkletree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "outboard"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// build the tree from the first half of the data
+	ft := NewStoredFullTree(h, NewOutboardNodeStore(f, h.Size()), 0)
+	if err := ft.ReadAll(bytes.NewReader(data[:leafSize*numLeaves/2]), leafSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen the file and append the second half
+	f, err = os.OpenFile(filepath.Join(dir, "outboard"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ft = NewStoredFullTree(h, NewOutboardNodeStore(f, h.Size()), numLeaves/2)
+	if err := ft.ReadAll(bytes.NewReader(data[leafSize*numLeaves/2:]), leafSize); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file should contain exactly the complete nodes of the tree
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expSize := int64(2*numLeaves-bits.OnesCount(numLeaves)) * int64(h.Size()); stat.Size() != expSize {
+		t.Fatalf("expected outboard file of size %v, got %v", expSize, stat.Size())
+	}
+
+	root, err := ft.Root()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(root, bytesRoot(data, h, leafSize)) {
+		t.Fatal("outboard tree has wrong root")
+	}
+	ranges := []LeafRange{{3, 7}, {50, 51}, {64, 99}}
+	proof, err := ft.ProveRange(ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expProof, err := BuildMultiRangeProof(ranges, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, h))
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(proof, expProof) {
+		t.Fatal("outboard tree produced wrong proof")
+	}
+
+	// reading past the end of the file should fail
+	ft = NewStoredFullTree(h, NewOutboardNodeStore(f, h.Size()), numLeaves+1)
+	if _, err := ft.Root(); err == nil {
+		t.Fatal("expected error when reading missing nodes")
+	}
+	if err := NewOutboardNodeStore(f, h.Size()).PutNode(0, 0, []byte{1}); err == nil {
+		t.Fatal("expected error when storing hash of wrong size")
+	}
+}
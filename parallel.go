@@ -0,0 +1,496 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// parallelChunkSize is the maximum number of bytes that a worker hashes at a
+// time in ParallelReaderRoot.
+const parallelChunkSize = 1 << 22
+
+// parallelSubtrees splits the leaves [0, numLeaves) into subtrees of at most
+// maxLeaves leaves each, where maxLeaves is a power of two. Pushing the roots
+// of the subtrees into a Tree in order, using PushSubTree, produces the Merkle
+// root of all of the leaves.
+func parallelSubtrees(numLeaves, maxLeaves uint64) []LeafRange {
+	var ranges []LeafRange
+	for start := uint64(0); start < numLeaves; {
+		n := uint64(nextSubtreeSize(start, numLeaves))
+		if n > maxLeaves {
+			n = maxLeaves
+		}
+		ranges = append(ranges, LeafRange{start, start + n})
+		start += n
+	}
+	return ranges
+}
+
+// readerAtSubtreeRoot returns the Merkle root of the leaves within r, using buf
+// to hold the leaf data. The final leaf of the reader may be shorter than
+// segmentSize.
+func readerAtSubtreeRoot(r io.ReaderAt, size int64, h hash.Hash, segmentSize int, leaves LeafRange, buf []byte) ([]byte, error) {
+	off := int64(leaves.Start) * int64(segmentSize)
+	end := int64(leaves.End) * int64(segmentSize)
+	if end > size {
+		end = size
+	}
+	data := buf[:end-off]
+	if n, err := r.ReadAt(data, off); n < len(data) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	tree := New(h)
+	for len(data) > 0 {
+		n := segmentSize
+		if n > len(data) {
+			n = len(data)
+		}
+		tree.Push(data[:n])
+		data = data[n:]
+	}
+	return tree.Root(), nil
+}
+
+// ParallelReaderRoot returns the Merkle root of the first size bytes of r,
+// where each leaf is 'segmentSize' long. The result is identical to the result
+// of ReaderRoot, but the data is split into subtrees that are hashed
+// concurrently by the specified number of workers, each using its own hash
+// created by newHash. If workers is less than 1, runtime.NumCPU() workers are
+// used. If r contains fewer than size bytes, io.ErrUnexpectedEOF is returned.
+func ParallelReaderRoot(r io.ReaderAt, size int64, newHash func() hash.Hash, segmentSize int, workers int) ([]byte, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	numLeaves := uint64((size + int64(segmentSize) - 1) / int64(segmentSize))
+	maxLeaves := uint64(1)
+	for maxLeaves*2*uint64(segmentSize) <= parallelChunkSize {
+		maxLeaves *= 2
+	}
+	bufSize := int64(maxLeaves) * int64(segmentSize)
+	if bufSize > size {
+		bufSize = size
+	}
+
+	// Hash the subtrees concurrently. Once any worker fails, the remaining
+	// subtrees are skipped.
+	subtrees := parallelSubtrees(numLeaves, maxLeaves)
+	roots := make([][]byte, len(subtrees))
+	errs := make([]error, len(subtrees))
+	jobs := make(chan int)
+	var failed int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := newHash()
+			buf := make([]byte, bufSize)
+			for j := range jobs {
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				roots[j], errs[j] = readerAtSubtreeRoot(r, size, h, segmentSize, subtrees[j], buf)
+				if errs[j] != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	for j := range subtrees {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Combine the subtree roots.
+	tree := New(newHash())
+	for j, st := range subtrees {
+		height := bits.TrailingZeros64(st.End - st.Start)
+		if err := tree.PushSubTree(height, roots[j]); err != nil {
+			return nil, err
+		}
+	}
+	return tree.Root(), nil
+}
+
+// ParallelReaderSubtreeHasher implements SubtreeHasher by reading leaf data
+// from an underlying stream, like ReaderSubtreeHasher. Large subtrees are
+// split into chunks that are hashed concurrently by a pool of workers, each
+// taking a hash from a pool of hashes created by a factory.
+type ParallelReaderSubtreeHasher struct {
+	r        io.Reader
+	leafSize int
+	workers  int
+	hashes   sync.Pool
+}
+
+// NextSubtreeRoot implements SubtreeHasher.
+func (prsh *ParallelReaderSubtreeHasher) NextSubtreeRoot(subtreeSize int) ([]byte, error) {
+	maxLeaves := uint64(1)
+	for maxLeaves*2*uint64(prsh.leafSize) <= parallelChunkSize {
+		maxLeaves *= 2
+	}
+	h := prsh.hashes.Get().(hash.Hash)
+	defer prsh.hashes.Put(h)
+	tree := New(h)
+
+	// The subtree is read sequentially in chunks, in the same way as
+	// parallelSubtrees splits a tree, and each complete chunk is hashed on its
+	// own goroutine. pending holds the chunks that are being hashed, in order,
+	// and is limited to one chunk per worker; the same goes for the buffers
+	// holding the chunk data.
+	type chunk struct {
+		height int
+		root   chan []byte
+	}
+	var pending []chunk
+	flush := func(n int) error {
+		for len(pending) > n {
+			c := pending[0]
+			pending = pending[1:]
+			if err := tree.PushSubTree(c.height, <-c.root); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	defer func() {
+		// wait for any chunks that are still being hashed after an error
+		for _, c := range pending {
+			<-c.root
+		}
+	}()
+	bufs := make(chan []byte, prsh.workers)
+	numBufs := 0
+
+	var partial []byte
+	for leafIndex := uint64(0); leafIndex < uint64(subtreeSize); {
+		size := uint64(nextSubtreeSize(leafIndex, uint64(subtreeSize)))
+		if size > maxLeaves {
+			size = maxLeaves
+		}
+		if err := flush(prsh.workers - 1); err != nil {
+			return nil, err
+		}
+		var buf []byte
+		if numBufs < prsh.workers {
+			buf = make([]byte, maxLeaves*uint64(prsh.leafSize))
+			numBufs++
+		} else {
+			buf = <-bufs
+		}
+		buf = buf[:size*uint64(prsh.leafSize)]
+		n, err := io.ReadFull(prsh.r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// reading a partial chunk is normal at the end of the stream
+			partial = buf[:n]
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		c := chunk{
+			height: bits.TrailingZeros64(size),
+			root:   make(chan []byte, 1),
+		}
+		pending = append(pending, c)
+		go func(buf []byte) {
+			h := prsh.hashes.Get().(hash.Hash)
+			tree := New(h)
+			for i := 0; i < len(buf); i += prsh.leafSize {
+				tree.Push(buf[i : i+prsh.leafSize])
+			}
+			prsh.hashes.Put(h)
+			bufs <- buf
+			c.root <- tree.Root()
+		}(buf)
+		leafIndex += size
+	}
+	if err := flush(0); err != nil {
+		return nil, err
+	}
+
+	// The leaves of the final, incomplete chunk are hashed one by one.
+	for len(partial) > 0 {
+		n := prsh.leafSize
+		if n > len(partial) {
+			n = len(partial)
+		}
+		if err := tree.PushSubTree(0, leafSum(h, partial[:n])); err != nil {
+			return nil, err
+		}
+		partial = partial[n:]
+	}
+	root := tree.Root()
+	if root == nil {
+		// we didn't read anything; return EOF to signal that there are no
+		// more subtrees to hash.
+		return nil, io.EOF
+	}
+	return root, nil
+}
+
+// Skip implements SubtreeHasher.
+func (prsh *ParallelReaderSubtreeHasher) Skip(n int) error {
+	skipSize := int64(prsh.leafSize * n)
+	skipped, err := io.CopyN(ioutil.Discard, prsh.r, skipSize)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if skipped == skipSize {
+			return nil
+		}
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// NewParallelReaderSubtreeHasher returns a new ParallelReaderSubtreeHasher
+// that reads leaf data from r and hashes it using the specified number of
+// workers. Each worker hashes with a hash created by newHash. If workers is
+// less than 1, runtime.NumCPU() workers are used.
+func NewParallelReaderSubtreeHasher(r io.Reader, leafSize int, newHash func() hash.Hash, workers int) *ParallelReaderSubtreeHasher {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	return &ParallelReaderSubtreeHasher{
+		r:        r,
+		leafSize: leafSize,
+		workers:  workers,
+		hashes: sync.Pool{
+			New: func() interface{} { return newHash() },
+		},
+	}
+}
+
+// ParallelReaderAtSubtreeHasher implements SubtreeHasher by reading leaf data
+// from an io.ReaderAt, like ReaderAtSubtreeHasher. Since it is given the proof
+// ranges up front, it knows every subtree that BuildMultiRangeProof will ask
+// for, and hashes them ahead of time on a pool of workers, splitting large
+// subtrees into chunks in the same way as ParallelReaderRoot. The roots are
+// still returned in the order in which the subtrees are requested.
+//
+// The workers are started by the first call to NextSubtreeRoot and exit once
+// every subtree has been hashed. If the proof is abandoned before that, e.g.
+// because building it failed, Close should be called to stop them early.
+type ParallelReaderAtSubtreeHasher struct {
+	r        io.ReaderAt
+	size     int64
+	leafSize int
+	newHash  func() hash.Hash
+	h        hash.Hash
+	workers  int
+
+	// subtrees holds the subtrees that have not been requested yet, in
+	// order, and chunks holds the chunks that they are split into.
+	subtrees  []parallelSubtree
+	chunks    []parallelChunk
+	leafIndex uint64
+
+	start sync.Once
+	stop  chan struct{}
+	close sync.Once
+}
+
+// errHasherClosed is returned by a ParallelReaderAtSubtreeHasher that has
+// been closed.
+var errHasherClosed = errors.New("hasher has been closed")
+
+// A parallelSubtree is a subtree whose root is part of a range proof, made up
+// of the chunks [firstChunk, endChunk).
+type parallelSubtree struct {
+	leaves               LeafRange
+	firstChunk, endChunk int
+}
+
+// A parallelChunk is a part of a parallelSubtree that is hashed by a single
+// worker. done is closed once root or err has been set.
+type parallelChunk struct {
+	leaves LeafRange
+	root   []byte
+	err    error
+	done   chan struct{}
+}
+
+// NewParallelReaderAtSubtreeHasher returns a new
+// ParallelReaderAtSubtreeHasher that reads the leaf data of the first size
+// bytes of r, and hashes the subtrees of a proof for the specified ranges
+// using the specified number of workers. Each worker hashes with a hash
+// created by newHash. If workers is less than 1, runtime.NumCPU() workers are
+// used. The ranges must be the same as those passed to BuildMultiRangeProof.
+func NewParallelReaderAtSubtreeHasher(r io.ReaderAt, size int64, leafSize int, newHash func() hash.Hash, ranges []LeafRange, workers int) *ParallelReaderAtSubtreeHasher {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	prsh := &ParallelReaderAtSubtreeHasher{
+		r:        r,
+		size:     size,
+		leafSize: leafSize,
+		newHash:  newHash,
+		h:        newHash(),
+		workers:  workers,
+		stop:     make(chan struct{}),
+	}
+	numLeaves := prsh.numLeaves()
+	maxLeaves := prsh.maxLeaves()
+
+	// Walk the tree in the same way as BuildMultiRangeProof, recording the
+	// subtrees that it consumes. Subtrees are clamped to the end of the data,
+	// and split into chunks in the same way as parallelSubtrees splits a tree.
+	var leafIndex uint64
+	consumeUntil := func(end uint64) {
+		for leafIndex != end && leafIndex < numLeaves {
+			st := parallelSubtree{
+				leaves:     LeafRange{leafIndex, leafIndex + uint64(nextSubtreeSize(leafIndex, end))},
+				firstChunk: len(prsh.chunks),
+			}
+			if st.leaves.End > numLeaves {
+				st.leaves.End = numLeaves
+			}
+			for start := st.leaves.Start; start < st.leaves.End; {
+				n := uint64(nextSubtreeSize(start, st.leaves.End))
+				if n > maxLeaves {
+					n = maxLeaves
+				}
+				prsh.chunks = append(prsh.chunks, parallelChunk{
+					leaves: LeafRange{start, start + n},
+					done:   make(chan struct{}),
+				})
+				start += n
+			}
+			st.endChunk = len(prsh.chunks)
+			prsh.subtrees = append(prsh.subtrees, st)
+			leafIndex = st.leaves.End
+		}
+	}
+	if len(ranges) > 0 {
+		for _, r := range ranges {
+			consumeUntil(r.Start)
+			leafIndex = r.End
+		}
+		consumeUntil(math.MaxUint64)
+	}
+	return prsh
+}
+
+// numLeaves returns the number of leaves in the data, counting a partial
+// final leaf.
+func (prsh *ParallelReaderAtSubtreeHasher) numLeaves() uint64 {
+	return uint64((prsh.size + int64(prsh.leafSize) - 1) / int64(prsh.leafSize))
+}
+
+// maxLeaves returns the maximum number of leaves in a chunk.
+func (prsh *ParallelReaderAtSubtreeHasher) maxLeaves() uint64 {
+	maxLeaves := uint64(1)
+	for maxLeaves*2*uint64(prsh.leafSize) <= parallelChunkSize {
+		maxLeaves *= 2
+	}
+	return maxLeaves
+}
+
+// startWorkers starts hashing the chunks, in order, on the worker pool.
+func (prsh *ParallelReaderAtSubtreeHasher) startWorkers() {
+	bufSize := int64(prsh.maxLeaves()) * int64(prsh.leafSize)
+	if bufSize > prsh.size {
+		bufSize = prsh.size
+	}
+	jobs := make(chan int)
+	for i := 0; i < prsh.workers; i++ {
+		go func() {
+			h := prsh.newHash()
+			buf := make([]byte, bufSize)
+			for j := range jobs {
+				c := &prsh.chunks[j]
+				c.root, c.err = readerAtSubtreeRoot(prsh.r, prsh.size, h, prsh.leafSize, c.leaves, buf)
+				close(c.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for j := range prsh.chunks {
+			select {
+			case jobs <- j:
+			case <-prsh.stop:
+				return
+			}
+		}
+	}()
+}
+
+// NextSubtreeRoot implements SubtreeHasher. It returns an error if the
+// subtree is not the next one of the proof for the ranges of the hasher.
+func (prsh *ParallelReaderAtSubtreeHasher) NextSubtreeRoot(subtreeSize int) ([]byte, error) {
+	numLeaves := prsh.numLeaves()
+	if prsh.leafIndex >= numLeaves {
+		return nil, io.EOF
+	}
+	end := prsh.leafIndex + uint64(subtreeSize)
+	if end > numLeaves {
+		end = numLeaves // the subtree may extend past the end of the data
+	}
+	if len(prsh.subtrees) == 0 || prsh.subtrees[0].leaves != (LeafRange{prsh.leafIndex, end}) {
+		return nil, errors.New("subtree is not part of the proof for the ranges of the hasher")
+	}
+	select {
+	case <-prsh.stop:
+		return nil, errHasherClosed
+	default:
+	}
+	st := prsh.subtrees[0]
+	prsh.subtrees = prsh.subtrees[1:]
+	prsh.start.Do(prsh.startWorkers)
+
+	tree := New(prsh.h)
+	for j := st.firstChunk; j < st.endChunk; j++ {
+		c := &prsh.chunks[j]
+		select {
+		case <-c.done:
+		case <-prsh.stop:
+			return nil, errHasherClosed
+		}
+		if c.err != nil {
+			prsh.Close()
+			return nil, c.err
+		}
+		if err := tree.PushSubTree(bits.TrailingZeros64(c.leaves.End-c.leaves.Start), c.root); err != nil {
+			return nil, err
+		}
+	}
+	prsh.leafIndex = end
+	return tree.Root(), nil
+}
+
+// Skip implements SubtreeHasher. It does not read any data. As with
+// ReaderSubtreeHasher, only complete leaves can be skipped, so skipping the
+// partial final leaf returns io.ErrUnexpectedEOF.
+func (prsh *ParallelReaderAtSubtreeHasher) Skip(n int) error {
+	if int64(prsh.leafIndex+uint64(n))*int64(prsh.leafSize) > prsh.size {
+		return io.ErrUnexpectedEOF
+	}
+	prsh.leafIndex += uint64(n)
+	return nil
+}
+
+// Close stops the workers from hashing any further chunks. After Close,
+// NextSubtreeRoot returns an error for any subtree that has not been hashed
+// yet.
+func (prsh *ParallelReaderAtSubtreeHasher) Close() error {
+	prsh.close.Do(func() { close(prsh.stop) })
+	return nil
+}
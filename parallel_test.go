@@ -0,0 +1,200 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestParallelReaderRoot tests that ParallelReaderRoot produces the same
+// roots as ReaderRoot.
+func TestParallelReaderRoot(t *testing.T) {
+	tests := []struct {
+		size        int
+		segmentSize int
+	}{
+		{0, 64},
+		{1, 64},
+		{63, 64},
+		{64, 64},
+		{65, 64},
+		{64 * 1000, 64},
+		{64*1000 + 7, 64},
+		{3 * parallelChunkSize, 1 << 19},
+		{3*parallelChunkSize + (5 << 19) + 100, 1 << 19},
+		{parallelChunkSize + 1, parallelChunkSize * 2},
+	}
+	for _, test := range tests {
+		data := fastrand.Bytes(test.size)
+		expRoot, err := ReaderRoot(bytes.NewReader(data), sha256.New(), test.segmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{0, 1, 3, 8} {
+			root, err := ParallelReaderRoot(bytes.NewReader(data), int64(len(data)), sha256.New, test.segmentSize, workers)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(root, expRoot) {
+				t.Errorf("wrong root for size %v, segment size %v, %v workers", test.size, test.segmentSize, workers)
+			}
+		}
+	}
+
+	// a reader that is too short should fail
+	data := fastrand.Bytes(1000)
+	if _, err := ParallelReaderRoot(bytes.NewReader(data), 1001, sha256.New, 64, 4); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", err)
+	}
+}
+
+// TestParallelReaderSubtreeHasher tests that ParallelReaderSubtreeHasher
+// produces the same proofs as ReaderSubtreeHasher.
+func TestParallelReaderSubtreeHasher(t *testing.T) {
+	tests := []struct {
+		leafSize  int
+		numLeaves int
+		ranges    []LeafRange
+	}{
+		{64, 1, []LeafRange{{0, 1}}},
+		{64, 100, []LeafRange{{3, 7}, {50, 51}, {64, 99}}},
+		{64, 100, []LeafRange{{99, 100}}},
+		// with leaves of 512 KiB, each chunk holds only 8 leaves, so the
+		// subtrees surrounding the ranges span several chunks
+		{1 << 19, 45, []LeafRange{{0, 1}}},
+		{1 << 19, 45, []LeafRange{{33, 34}}},
+		{1 << 19, 45, []LeafRange{{20, 21}, {44, 45}}},
+	}
+	for _, test := range tests {
+		data := fastrand.Bytes(test.leafSize * test.numLeaves)
+		if test.ranges[len(test.ranges)-1].End < uint64(test.numLeaves) {
+			// make the final leaf partial; leaves within the proof ranges
+			// are skipped and must therefore be complete
+			data = data[:len(data)-test.leafSize/3]
+		}
+		expProof, err := BuildMultiRangeProof(test.ranges, NewReaderSubtreeHasher(bytes.NewReader(data), test.leafSize, sha256.New()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{0, 1, 3} {
+			sh := NewParallelReaderSubtreeHasher(bytes.NewReader(data), test.leafSize, sha256.New, workers)
+			proof, err := BuildMultiRangeProof(test.ranges, sh)
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(proof, expProof) {
+				t.Errorf("wrong proof for %v leaves, ranges %v, %v workers", test.numLeaves, test.ranges, workers)
+			}
+		}
+	}
+
+	// skipping past the end of the data should fail
+	data := fastrand.Bytes(64 * 100)
+	sh := NewParallelReaderSubtreeHasher(bytes.NewReader(data[:len(data)/2]), 64, sha256.New, 0)
+	if _, err := BuildRangeProof(49, 51, sh); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", err)
+	}
+}
+
+// TestParallelReaderAtSubtreeHasher tests that a
+// ParallelReaderAtSubtreeHasher produces the same proofs as a
+// ReaderSubtreeHasher.
+func TestParallelReaderAtSubtreeHasher(t *testing.T) {
+	tests := []struct {
+		leafSize  int
+		numLeaves int
+		ranges    []LeafRange
+	}{
+		{64, 1, []LeafRange{{0, 1}}},
+		{64, 100, []LeafRange{{3, 7}, {50, 51}, {64, 99}}},
+		{64, 100, []LeafRange{{99, 100}}},
+		{64, 100, []LeafRange{{0, 100}}},
+		// with leaves of 512 KiB, each chunk holds only 8 leaves, so the
+		// subtrees surrounding the ranges span several chunks
+		{1 << 19, 45, []LeafRange{{0, 1}}},
+		{1 << 19, 45, []LeafRange{{33, 34}}},
+		{1 << 19, 45, []LeafRange{{20, 21}, {44, 45}}},
+	}
+	for _, test := range tests {
+		data := fastrand.Bytes(test.leafSize * test.numLeaves)
+		if test.ranges[len(test.ranges)-1].End < uint64(test.numLeaves) {
+			// make the final leaf partial; leaves within the proof ranges
+			// are skipped and must therefore be complete
+			data = data[:len(data)-test.leafSize/3]
+		}
+		expProof, err := BuildMultiRangeProof(test.ranges, NewReaderSubtreeHasher(bytes.NewReader(data), test.leafSize, sha256.New()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{0, 1, 3} {
+			sh := NewParallelReaderAtSubtreeHasher(bytes.NewReader(data), int64(len(data)), test.leafSize, sha256.New, test.ranges, workers)
+			proof, err := BuildMultiRangeProof(test.ranges, sh)
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(proof, expProof) {
+				t.Errorf("wrong proof for %v leaves, ranges %v, %v workers", test.numLeaves, test.ranges, workers)
+			}
+			sh = NewParallelReaderAtSubtreeHasher(bytes.NewReader(data), int64(len(data)), test.leafSize, sha256.New, test.ranges, workers)
+			proof, err = BuildMultiRangeProofWithSize(test.ranges, sh, uint64(test.numLeaves))
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(proof, expProof) {
+				t.Errorf("wrong sized proof for %v leaves, ranges %v, %v workers", test.numLeaves, test.ranges, workers)
+			}
+		}
+	}
+
+	// as with ReaderSubtreeHasher, skipping the partial final leaf should
+	// fail
+	data := fastrand.Bytes(64*100 - 10)
+	sh := NewParallelReaderAtSubtreeHasher(bytes.NewReader(data), int64(len(data)), 64, sha256.New, []LeafRange{{98, 100}}, 0)
+	if _, err := BuildRangeProof(98, 100, sh); err != io.ErrUnexpectedEOF {
+		t.Error("expected io.ErrUnexpectedEOF, got", err)
+	}
+	// building a proof for other ranges should fail
+	data = fastrand.Bytes(64 * 100)
+	sh = NewParallelReaderAtSubtreeHasher(bytes.NewReader(data), int64(len(data)), 64, sha256.New, []LeafRange{{3, 7}}, 0)
+	if _, err := BuildRangeProof(10, 12, sh); err == nil {
+		t.Error("expected error when building a proof for other ranges")
+	}
+	// a reader that is too short should fail
+	sh = NewParallelReaderAtSubtreeHasher(bytes.NewReader(data[:len(data)/2]), int64(len(data)), 64, sha256.New, []LeafRange{{3, 7}}, 0)
+	if _, err := BuildRangeProof(3, 7, sh); err != io.ErrUnexpectedEOF {
+		t.Error("expected io.ErrUnexpectedEOF, got", err)
+	}
+	// a closed hasher should fail
+	sh = NewParallelReaderAtSubtreeHasher(bytes.NewReader(data), int64(len(data)), 64, sha256.New, []LeafRange{{3, 7}}, 0)
+	if err := sh.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err := BuildRangeProof(3, 7, sh); err == nil {
+		t.Error("expected error when using a closed hasher")
+	}
+}
+
+// BenchmarkParallelReaderAtSubtreeHasher benchmarks building a proof for a
+// single leaf of 64 MiB of data with a ParallelReaderAtSubtreeHasher.
+func BenchmarkParallelReaderAtSubtreeHasher(b *testing.B) {
+	data := fastrand.Bytes(1 << 26)
+	ranges := []LeafRange{{1 << 13, 1<<13 + 1}}
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		sh := NewParallelReaderAtSubtreeHasher(bytes.NewReader(data), int64(len(data)), 4096, sha256.New, ranges, 0)
+		if _, err := BuildMultiRangeProof(ranges, sh); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParallelReaderRoot benchmarks ParallelReaderRoot on 64 MiB of data.
+func BenchmarkParallelReaderRoot(b *testing.B) {
+	data := fastrand.Bytes(1 << 26)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		_, err := ParallelReaderRoot(bytes.NewReader(data), int64(len(data)), sha256.New, 4096, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// proofVersion is the version of the encoding produced by
+// (*Proof).MarshalBinary. It is the first byte of every encoded proof.
+const proofVersion = 1
+
+// Identifiers of the hash functions that are registered by default. The
+// identifier of a proof created by the merkletree-blake package is always
+// HashBLAKE2b256.
+const (
+	HashSHA256     = "sha256"
+	HashBLAKE2b256 = "blake2b-256"
+)
+
+var (
+	hashFuncsMu sync.RWMutex
+	hashFuncs   = map[string]func() hash.Hash{
+		HashSHA256: sha256.New,
+		HashBLAKE2b256: func() hash.Hash {
+			h, _ := blake2b.New256(nil) // only fails for keys that are too long
+			return h
+		},
+	}
+)
+
+// RegisterHash registers a hash function under the specified identifier, so
+// that (*Proof).Verify can verify proofs that were created with it.
+// Registering an identifier a second time replaces the previous hash
+// function.
+func RegisterHash(id string, newHash func() hash.Hash) {
+	hashFuncsMu.Lock()
+	defer hashFuncsMu.Unlock()
+	hashFuncs[id] = newHash
+}
+
+// lookupHash returns a new hash for the specified identifier.
+func lookupHash(id string) (hash.Hash, error) {
+	hashFuncsMu.RLock()
+	defer hashFuncsMu.RUnlock()
+	newHash, ok := hashFuncs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash function %q", id)
+	}
+	return newHash(), nil
+}
+
+// A ProofKind identifies the kind of proof held by a Proof.
+type ProofKind uint8
+
+const (
+	// ProofSingle is a proof for a single leaf, as produced by (*Tree).Prove.
+	ProofSingle ProofKind = iota + 1
+	// ProofRange is a proof for a set of leaf ranges, as produced by
+	// BuildMultiRangeProof.
+	ProofRange
+	// ProofDiff is a diff proof, as produced by BuildDiffProof.
+	ProofDiff
+)
+
+// String implements fmt.Stringer.
+func (k ProofKind) String() string {
+	switch k {
+	case ProofSingle:
+		return "single"
+	case ProofRange:
+		return "range"
+	case ProofDiff:
+		return "diff"
+	default:
+		return fmt.Sprintf("ProofKind(%d)", uint8(k))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k ProofKind) MarshalText() ([]byte, error) {
+	switch k {
+	case ProofSingle, ProofRange, ProofDiff:
+		return []byte(k.String()), nil
+	default:
+		return nil, fmt.Errorf("invalid proof kind %d", uint8(k))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *ProofKind) UnmarshalText(b []byte) error {
+	for _, kind := range []ProofKind{ProofSingle, ProofRange, ProofDiff} {
+		if string(b) == kind.String() {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid proof kind %q", b)
+}
+
+// A Proof is a self-describing Merkle proof. Along with the proof hashes, it
+// records the kind of proof, the hash function of the tree, and everything
+// else that the verifier of that kind of proof needs, so that the proof can be
+// stored or sent as a single value and verified with Verify.
+//
+// A Proof can be encoded with MarshalBinary, using a versioned binary
+// encoding in which every list and byte slice is prefixed with its length,
+// or with encoding/json.
+type Proof struct {
+	Kind ProofKind `json:"kind"`
+	// Hash is the identifier of the hash function, see RegisterHash.
+	Hash string `json:"hash"`
+	// NumLeaves is the number of leaves in the tree. It is required for
+	// single and diff proofs. For range proofs it is optional; if it is not
+	// zero, the proof is verified with VerifyMultiRangeProofWithSize.
+	NumLeaves uint64 `json:"numLeaves"`
+	// Index is the index of the leaf of a single proof.
+	Index uint64 `json:"index"`
+	// Ranges are the leaf ranges of a range or diff proof.
+	Ranges []LeafRange `json:"ranges,omitempty"`
+	// Data is the leaf data of a single proof.
+	Data []byte `json:"data,omitempty"`
+	// Leaves are the leaf hashes within the ranges of a range proof, or the
+	// subtree hashes within the ranges of a diff proof, as produced by
+	// CompressLeafHashes.
+	Leaves [][]byte `json:"leaves,omitempty"`
+	// Hashes are the proof hashes.
+	Hashes [][]byte `json:"hashes"`
+}
+
+// NewSingleProof returns a Proof holding the proof set produced by
+// (*Tree).Prove, for a tree that uses the hash function identified by
+// hashID.
+func NewSingleProof(hashID string, proofSet [][]byte, proofIndex, numLeaves uint64) *Proof {
+	p := &Proof{
+		Kind:      ProofSingle,
+		Hash:      hashID,
+		NumLeaves: numLeaves,
+		Index:     proofIndex,
+	}
+	if len(proofSet) > 0 {
+		p.Data = proofSet[0]
+		p.Hashes = proofSet[1:]
+	}
+	return p
+}
+
+// NewRangeProof returns a Proof holding a proof produced by
+// BuildMultiRangeProof together with the leaf hashes within the ranges. If
+// numLeaves is not zero, the proof is verified against that number of leaves.
+func NewRangeProof(hashID string, ranges []LeafRange, leafHashes, proof [][]byte, numLeaves uint64) *Proof {
+	return &Proof{
+		Kind:      ProofRange,
+		Hash:      hashID,
+		NumLeaves: numLeaves,
+		Ranges:    ranges,
+		Leaves:    leafHashes,
+		Hashes:    proof,
+	}
+}
+
+// NewDiffProof returns a Proof holding a proof produced by BuildDiffProof
+// together with the subtree hashes within the ranges.
+func NewDiffProof(hashID string, ranges []LeafRange, rangeHashes, proof [][]byte, numLeaves uint64) *Proof {
+	return &Proof{
+		Kind:      ProofDiff,
+		Hash:      hashID,
+		NumLeaves: numLeaves,
+		Ranges:    ranges,
+		Leaves:    rangeHashes,
+		Hashes:    proof,
+	}
+}
+
+// Verify verifies the proof against the Merkle root, using the verifier of
+// its kind. An error is returned if the proof is malformed, e.g. if its hash
+// function is unknown or its ranges are invalid or missing.
+func (p *Proof) Verify(root []byte) (bool, error) {
+	h, err := lookupHash(p.Hash)
+	if err != nil {
+		return false, err
+	}
+	// Without ranges, a range or diff proof does not authenticate any leaves,
+	// so it must not be accepted for an arbitrary root.
+	if (p.Kind == ProofRange || p.Kind == ProofDiff) && len(p.Ranges) == 0 {
+		return false, errors.New("proof has no ranges")
+	}
+	switch p.Kind {
+	case ProofSingle:
+		proofSet := append([][]byte{p.Data}, p.Hashes...)
+		return VerifyProof(h, root, proofSet, p.Index, p.NumLeaves), nil
+	case ProofRange:
+		if err := checkRangeSet(p.Ranges); err != nil {
+			return false, err
+		}
+		var numRangeLeaves uint64
+		for _, r := range p.Ranges {
+			numRangeLeaves += r.End - r.Start
+		}
+		if uint64(len(p.Leaves)) < numRangeLeaves {
+			return false, ErrProofTooShort
+		} else if uint64(len(p.Leaves)) > numRangeLeaves {
+			return false, ErrProofTooLong
+		}
+		lh := NewCachedLeafHasher(p.Leaves)
+		if p.NumLeaves != 0 {
+			return VerifyMultiRangeProofWithSize(lh, h, p.Ranges, p.Hashes, root, p.NumLeaves)
+		}
+		return VerifyMultiRangeProofChecked(lh, h, p.Ranges, p.Hashes, root)
+	case ProofDiff:
+		return VerifyDiffProofChecked(p.Leaves, p.NumLeaves, h, p.Ranges, p.Hashes, root)
+	default:
+		return false, fmt.Errorf("invalid proof kind %d", uint8(p.Kind))
+	}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	if _, err := p.Kind.MarshalText(); err != nil {
+		return nil, err
+	}
+	e := checkpointEncoder{proofVersion, byte(p.Kind)}
+	e.writePrefixedBytes([]byte(p.Hash))
+	e.writeUint64(p.NumLeaves)
+	e.writeUint64(p.Index)
+	e.writeUint64(uint64(len(p.Ranges)))
+	for _, r := range p.Ranges {
+		e.writeUint64(r.Start)
+		e.writeUint64(r.End)
+	}
+	// The leaf data of a single proof is stored in the list of leaves.
+	leaves := p.Leaves
+	if p.Kind == ProofSingle {
+		leaves = [][]byte{p.Data}
+	}
+	for _, hashes := range [][][]byte{leaves, p.Hashes} {
+		e.writeUint64(uint64(len(hashes)))
+		for _, h := range hashes {
+			e.writePrefixedBytes(h)
+		}
+	}
+	return e, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *Proof) UnmarshalBinary(b []byte) error {
+	d := checkpointDecoder{buf: b}
+	header := d.readBytes(2)
+	if d.err != nil {
+		return d.err
+	} else if header[0] != proofVersion {
+		return fmt.Errorf("unsupported proof version %v", header[0])
+	}
+	kind := ProofKind(header[1])
+	if _, err := kind.MarshalText(); err != nil {
+		return err
+	}
+	hashID := string(d.readPrefixedBytes())
+	numLeaves := d.readUint64()
+	index := d.readUint64()
+
+	// Every element takes up at least 8 bytes, so a count that exceeds the
+	// remaining bytes is invalid. Checking this up front prevents large
+	// allocations when decoding garbage.
+	readCount := func(elemSize int) int {
+		n := d.readUint64()
+		if d.err == nil && n > uint64(len(d.buf)/elemSize) {
+			d.err = errors.New("proof contains too many elements")
+		}
+		return int(n)
+	}
+	var ranges []LeafRange
+	for i, n := 0, readCount(16); i < n && d.err == nil; i++ {
+		ranges = append(ranges, LeafRange{d.readUint64(), d.readUint64()})
+	}
+	var lists [2][][]byte
+	for j := range lists {
+		for i, n := 0, readCount(8); i < n && d.err == nil; i++ {
+			lists[j] = append(lists[j], d.readPrefixedBytes())
+		}
+	}
+	if d.err != nil {
+		return d.err
+	} else if len(d.buf) != 0 {
+		return errors.New("proof has trailing bytes")
+	}
+
+	var data []byte
+	if kind == ProofSingle {
+		if len(lists[0]) != 1 {
+			return errors.New("single proof must contain exactly one leaf")
+		}
+		data, lists[0] = lists[0][0], nil
+	}
+	*p = Proof{
+		Kind:      kind,
+		Hash:      hashID,
+		NumLeaves: numLeaves,
+		Index:     index,
+		Ranges:    ranges,
+		Data:      data,
+		Leaves:    lists[0],
+		Hashes:    lists[1],
+	}
+	return nil
+}
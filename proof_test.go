@@ -0,0 +1,208 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+	"golang.org/x/crypto/blake2b"
+)
+
+// testProofs returns one proof of every kind for the specified data, along
+// with the Merkle root of the data.
+func testProofs(t *testing.T, hashID string, data []byte, leafSize int) ([]*Proof, []byte) {
+	h, err := lookupHash(hashID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numLeaves := uint64(len(data) / leafSize)
+	root := bytesRoot(data, h, leafSize)
+	leafHashes := make([][]byte, numLeaves)
+	for i := range leafHashes {
+		leafHashes[i] = leafSum(h, data[i*leafSize:][:leafSize])
+	}
+
+	tree := New(h)
+	if err := tree.SetIndex(numLeaves / 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ReadAll(bytes.NewReader(data), leafSize); err != nil {
+		t.Fatal(err)
+	}
+	_, proofSet, proofIndex, n := tree.Prove()
+
+	ranges := []LeafRange{{1, 4}, {9, 10}}
+	var rangeLeaves [][]byte
+	for _, r := range ranges {
+		rangeLeaves = append(rangeLeaves, leafHashes[r.Start:r.End]...)
+	}
+	rangeProof, err := BuildMultiRangeProof(ranges, NewCachedSubtreeHasher(leafHashes, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffProof, err := BuildDiffProof(ranges, NewCachedSubtreeHasher(leafHashes, h), numLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rangeHashes, err := CompressLeafHashes(ranges, NewCachedSubtreeHasher(rangeLeaves, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []*Proof{
+		NewSingleProof(hashID, proofSet, proofIndex, n),
+		NewRangeProof(hashID, ranges, rangeLeaves, rangeProof, 0),
+		NewRangeProof(hashID, ranges, rangeLeaves, rangeProof, numLeaves),
+		NewDiffProof(hashID, ranges, rangeHashes, diffProof, numLeaves),
+	}, root
+}
+
+// TestProofEncoding tests that proofs of every kind survive the binary and
+// JSON encodings and still verify afterwards.
+func TestProofEncoding(t *testing.T) {
+	const leafSize = 16
+	for _, hashID := range []string{HashSHA256, HashBLAKE2b256} {
+		data := fastrand.Bytes(leafSize * 13)
+		proofs, root := testProofs(t, hashID, data, leafSize)
+		badRoot := append([]byte(nil), root...)
+		badRoot[0] ^= 1
+		for _, p := range proofs {
+			if ok, err := p.Verify(root); !ok || err != nil {
+				t.Fatalf("%v %v proof failed to verify: %v", hashID, p.Kind, err)
+			}
+			if ok, _ := p.Verify(badRoot); ok {
+				t.Fatalf("%v %v proof verified against the wrong root", hashID, p.Kind)
+			}
+
+			b, err := p.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var bp Proof
+			if err := bp.UnmarshalBinary(b); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(&bp, p) {
+				t.Fatalf("%v proof changed after binary encoding", p.Kind)
+			}
+			js, err := json.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var jp Proof
+			if err := json.Unmarshal(js, &jp); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(&jp, p) {
+				t.Fatalf("%v proof changed after JSON encoding: %s", p.Kind, js)
+			}
+			if ok, err := jp.Verify(root); !ok || err != nil {
+				t.Fatalf("decoded %v proof failed to verify: %v", p.Kind, err)
+			}
+
+			// every truncation of the encoding should be rejected
+			for i := 0; i < len(b); i++ {
+				if err := new(Proof).UnmarshalBinary(b[:i]); err == nil {
+					t.Fatalf("truncated %v proof of %v bytes was accepted", p.Kind, i)
+				}
+			}
+			if err := new(Proof).UnmarshalBinary(append(b, 0)); err == nil {
+				t.Fatal("proof with trailing bytes was accepted")
+			}
+		}
+	}
+}
+
+// TestProofErrors tests that malformed proofs are rejected.
+func TestProofErrors(t *testing.T) {
+	const leafSize = 16
+	data := fastrand.Bytes(leafSize * 13)
+	proofs, root := testProofs(t, HashSHA256, data, leafSize)
+	single, rangeProof, diff := proofs[0], proofs[1], proofs[3]
+
+	b, _ := single.MarshalBinary()
+	b[0] = proofVersion + 1
+	if err := new(Proof).UnmarshalBinary(b); err == nil {
+		t.Error("expected error for unsupported version")
+	}
+	b[0], b[1] = proofVersion, 0
+	if err := new(Proof).UnmarshalBinary(b); err == nil {
+		t.Error("expected error for invalid kind")
+	}
+	if _, err := (&Proof{Kind: 7}).MarshalBinary(); err == nil {
+		t.Error("expected error when encoding an invalid kind")
+	}
+	if err := json.Unmarshal([]byte(`{"kind":"bogus"}`), new(Proof)); err == nil {
+		t.Error("expected error for invalid JSON kind")
+	}
+
+	p := *single
+	p.Hash = "md4"
+	if _, err := p.Verify(root); err == nil {
+		t.Error("expected error for unknown hash")
+	}
+	p = *rangeProof
+	p.Leaves = p.Leaves[1:]
+	if _, err := p.Verify(root); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort, got", err)
+	}
+	p = *rangeProof
+	p.Ranges = []LeafRange{{9, 10}, {1, 4}}
+	if _, err := p.Verify(root); err != ErrUnsortedRanges {
+		t.Error("expected ErrUnsortedRanges, got", err)
+	}
+	p = *diff
+	p.Hashes = append(p.Hashes, root)
+	if _, err := p.Verify(root); err != ErrProofTooLong {
+		t.Error("expected ErrProofTooLong, got", err)
+	}
+
+	// a decoded proof without ranges must not verify against any root
+	for _, kind := range []ProofKind{ProofRange, ProofDiff} {
+		b, err := (&Proof{Kind: kind, Hash: HashSHA256, NumLeaves: 5}).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p Proof
+		if err := p.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		} else if ok, err := p.Verify([]byte("definitely not a root")); ok || err == nil {
+			t.Errorf("%v proof without ranges was accepted", kind)
+		}
+	}
+
+	// a single proof without a leaf should be rejected, leaving the proof
+	// untouched
+	b, _ = (&Proof{Kind: ProofRange, Hash: HashSHA256, NumLeaves: 5}).MarshalBinary()
+	b[1] = byte(ProofSingle)
+	p = *single
+	if err := p.UnmarshalBinary(b); err == nil {
+		t.Error("expected error for single proof without a leaf")
+	} else if !reflect.DeepEqual(p, *single) {
+		t.Error("failed decode modified the proof")
+	}
+}
+
+// TestRegisterHash tests that proofs can be verified with a registered hash
+// function.
+func TestRegisterHash(t *testing.T) {
+	RegisterHash("sha512", sha512.New)
+	proofs, root := testProofs(t, "sha512", fastrand.Bytes(64*11), 64)
+	for _, p := range proofs {
+		if ok, err := p.Verify(root); !ok || err != nil {
+			t.Fatalf("%v proof failed to verify: %v", p.Kind, err)
+		}
+	}
+	// the default hash functions must match their identifiers
+	data := []byte("foo")
+	sha, blake := sha256.Sum256(data), blake2b.Sum256(data)
+	for id, exp := range map[string][]byte{HashSHA256: sha[:], HashBLAKE2b256: blake[:]} {
+		h, _ := lookupHash(id)
+		h.Write(data)
+		if !bytes.Equal(h.Sum(nil), exp) {
+			t.Error("wrong hash function for", id)
+		}
+	}
+}
@@ -28,15 +28,7 @@ func nextSubtreeSize(start, end uint64) int {
 
 // validRangeSet checks whether a set of ranges is sorted and non-overlapping.
 func validRangeSet(ranges []LeafRange) bool {
-	for i, r := range ranges {
-		if r.Start < 0 || r.Start >= r.End {
-			return false
-		}
-		if i > 0 && ranges[i-1].End > r.Start {
-			return false
-		}
-	}
-	return true
+	return checkRangeSet(ranges) == nil
 }
 
 // A SubtreeHasher calculates subtree roots in sequential order, for use with
@@ -184,6 +176,123 @@ func (msh *MixedSubtreeHasher) NextSubtreeRoot(subtreeSize int) ([]byte, error)
 	return msh.rsh.NextSubtreeRoot(subtreeSize)
 }
 
+// ReaderAtSubtreeHasher implements SubtreeHasher by reading leaf data from an
+// io.ReaderAt. Unlike ReaderSubtreeHasher, it skips leaves without reading
+// them, so building a proof only reads the leaves whose hashes are part of
+// the proof. If it is given the cached roots of the subtrees of
+// leavesPerNode leaves, it uses them instead of reading the leaves of whole
+// subtrees, and then only reads the leaves of the subtrees that are smaller
+// than leavesPerNode.
+type ReaderAtSubtreeHasher struct {
+	r         io.ReaderAt
+	size      int64
+	leafSize  int
+	h         hash.Hash
+	leafIndex uint64
+	buf       []byte // holds the leaf data of one chunk
+
+	nodeHashes    [][]byte
+	leavesPerNode int
+}
+
+// NewReaderAtSubtreeHasher returns a new ReaderAtSubtreeHasher that reads the
+// leaf data of the first size bytes of r.
+func NewReaderAtSubtreeHasher(r io.ReaderAt, size int64, leafSize int, h hash.Hash) *ReaderAtSubtreeHasher {
+	return &ReaderAtSubtreeHasher{
+		r:        r,
+		size:     size,
+		leafSize: leafSize,
+		h:        h,
+	}
+}
+
+// NewMixedReaderAtSubtreeHasher returns a new ReaderAtSubtreeHasher that
+// reads the leaf data of the first size bytes of r, and uses nodeHashes,
+// which are the roots of consecutive subtrees of leavesPerNode leaves,
+// wherever a subtree is made up of whole nodes. leavesPerNode must be a
+// power of two.
+func NewMixedReaderAtSubtreeHasher(nodeHashes [][]byte, r io.ReaderAt, size int64, leavesPerNode int, leafSize int, h hash.Hash) *ReaderAtSubtreeHasher {
+	rsh := NewReaderAtSubtreeHasher(r, size, leafSize, h)
+	rsh.nodeHashes = nodeHashes
+	rsh.leavesPerNode = leavesPerNode
+	return rsh
+}
+
+// numLeaves returns the number of leaves in the data, counting a partial
+// final leaf.
+func (rsh *ReaderAtSubtreeHasher) numLeaves() uint64 {
+	return uint64((rsh.size + int64(rsh.leafSize) - 1) / int64(rsh.leafSize))
+}
+
+// NextSubtreeRoot implements SubtreeHasher.
+func (rsh *ReaderAtSubtreeHasher) NextSubtreeRoot(subtreeSize int) ([]byte, error) {
+	numLeaves := rsh.numLeaves()
+	if rsh.leafIndex >= numLeaves {
+		return nil, io.EOF
+	}
+	end := rsh.leafIndex + uint64(subtreeSize)
+	if end > numLeaves {
+		end = numLeaves // the subtree may extend past the end of the data
+	}
+
+	tree := New(rsh.h)
+	if lpn := uint64(rsh.leavesPerNode); lpn > 0 && rsh.leafIndex%lpn == 0 && uint64(subtreeSize)%lpn == 0 {
+		// Use the cached roots of the nodes that make up the subtree, as
+		// long as all of them are available.
+		first, last := rsh.leafIndex/lpn, (end+lpn-1)/lpn
+		if last <= uint64(len(rsh.nodeHashes)) {
+			for _, nodeHash := range rsh.nodeHashes[first:last] {
+				if err := tree.PushSubTree(0, nodeHash); err != nil {
+					return nil, err
+				}
+			}
+			rsh.leafIndex = end
+			return tree.Root(), nil
+		}
+	}
+
+	// Read and hash the leaves in chunks of at most parallelChunkSize bytes,
+	// so that a large subtree is never held in memory at once.
+	maxLeaves := uint64(1)
+	for maxLeaves*2*uint64(rsh.leafSize) <= parallelChunkSize {
+		maxLeaves *= 2
+	}
+	bufLeaves := maxLeaves
+	if n := end - rsh.leafIndex; n < bufLeaves {
+		bufLeaves = n
+	}
+	if bufSize := bufLeaves * uint64(rsh.leafSize); uint64(len(rsh.buf)) < bufSize {
+		rsh.buf = make([]byte, bufSize)
+	}
+	for start := rsh.leafIndex; start < end; {
+		n := uint64(nextSubtreeSize(start, end))
+		if n > maxLeaves {
+			n = maxLeaves
+		}
+		root, err := readerAtSubtreeRoot(rsh.r, rsh.size, rsh.h, rsh.leafSize, LeafRange{start, start + n}, rsh.buf)
+		if err != nil {
+			return nil, err
+		}
+		if err := tree.PushSubTree(bits.TrailingZeros64(n), root); err != nil {
+			return nil, err
+		}
+		start += n
+	}
+	rsh.leafIndex = end
+	return tree.Root(), nil
+}
+
+// Skip implements SubtreeHasher. It does not read any data. As with
+// ReaderSubtreeHasher, only complete leaves can be skipped, so skipping the
+// partial final leaf returns io.ErrUnexpectedEOF.
+func (rsh *ReaderAtSubtreeHasher) Skip(n int) error {
+	if int64(rsh.leafIndex+uint64(n))*int64(rsh.leafSize) > rsh.size {
+		return io.ErrUnexpectedEOF
+	}
+	rsh.leafIndex += uint64(n)
+	return nil
+}
+
 // BuildMultiRangeProof constructs a proof for the specified leaf ranges, using
 // the provided SubtreeHasher. The ranges must be sorted and non-overlapping.
 func BuildMultiRangeProof(ranges []LeafRange, h SubtreeHasher) (proof [][]byte, err error) {
@@ -301,6 +410,25 @@ func BuildRangeProof(proofStart, proofEnd int, h SubtreeHasher) (proof [][]byte,
 	return BuildMultiRangeProof([]LeafRange{{uint64(proofStart), uint64(proofEnd)}}, h)
 }
 
+// BuildMultiRangeProofChecked is the same as BuildMultiRangeProof, except
+// that it returns ErrInvalidRange or ErrUnsortedRanges instead of panicking
+// if the ranges are invalid.
+func BuildMultiRangeProofChecked(ranges []LeafRange, h SubtreeHasher) ([][]byte, error) {
+	if err := checkRangeSet(ranges); err != nil {
+		return nil, err
+	}
+	return BuildMultiRangeProof(ranges, h)
+}
+
+// BuildRangeProofChecked is the same as BuildRangeProof, except that it
+// returns ErrInvalidRange instead of panicking if the range is invalid.
+func BuildRangeProofChecked(proofStart, proofEnd int, h SubtreeHasher) ([][]byte, error) {
+	if err := checkRange(proofStart, proofEnd); err != nil {
+		return nil, err
+	}
+	return BuildRangeProof(proofStart, proofEnd, h)
+}
+
 // A LeafHasher returns the leaves of a Merkle tree in sequential order. When
 // no more leaves are available, NextLeafHash must return io.EOF.
 type LeafHasher interface {
@@ -426,6 +554,159 @@ func VerifyRangeProof(lh LeafHasher, h hash.Hash, proofStart, proofEnd int, proo
 	return VerifyMultiRangeProof(lh, h, []LeafRange{{uint64(proofStart), uint64(proofEnd)}}, proof, root)
 }
 
+// VerifyMultiRangeProofChecked is the same as VerifyMultiRangeProof, except
+// that it returns ErrInvalidRange or ErrUnsortedRanges instead of panicking
+// if the ranges are invalid.
+func VerifyMultiRangeProofChecked(lh LeafHasher, h hash.Hash, ranges []LeafRange, proof [][]byte, root []byte) (bool, error) {
+	if err := checkRangeSet(ranges); err != nil {
+		return false, err
+	}
+	return VerifyMultiRangeProof(lh, h, ranges, proof, root)
+}
+
+// VerifyRangeProofChecked is the same as VerifyRangeProof, except that it
+// returns ErrInvalidRange instead of panicking if the range is invalid.
+func VerifyRangeProofChecked(lh LeafHasher, h hash.Hash, proofStart, proofEnd int, proof [][]byte, root []byte) (bool, error) {
+	if err := checkRange(proofStart, proofEnd); err != nil {
+		return false, err
+	}
+	return VerifyRangeProof(lh, h, proofStart, proofEnd, proof, root)
+}
+
+// tailSubtreeSize returns the number of leaves in the subtree that follows
+// the last proof range at leaf start in a tree of numLeaves leaves. The
+// subtrees are the same subtrees that BuildMultiRangeProof consumes, except
+// that the size of the last subtree is known: it contains the remaining
+// leaves of the tree.
+func tailSubtreeSize(start, numLeaves uint64) uint64 {
+	n := uint64(nextSubtreeSize(start, math.MaxUint64))
+	if n > numLeaves-start {
+		n = numLeaves - start
+	}
+	return n
+}
+
+// BuildMultiRangeProofWithSize constructs a proof for the specified leaf
+// ranges of a tree containing numLeaves leaves, using the provided
+// SubtreeHasher. The proof is identical to the proof produced by
+// BuildMultiRangeProof, but the ranges must lie within the first numLeaves
+// leaves, and the SubtreeHasher must produce at least numLeaves leaves. The
+// proof can be verified with VerifyMultiRangeProofWithSize.
+//
+// If the ranges are invalid, BuildMultiRangeProofWithSize returns
+// ErrInvalidRange, ErrUnsortedRanges, or ErrRangeOutOfBounds.
+func BuildMultiRangeProofWithSize(ranges []LeafRange, h SubtreeHasher, numLeaves uint64) (proof [][]byte, err error) {
+	if err := checkRangesWithin(ranges, numLeaves); err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+
+	var leafIndex uint64
+	consume := func(subtreeSize uint64) error {
+		root, err := h.NextSubtreeRoot(int(subtreeSize))
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		proof = append(proof, root)
+		leafIndex += subtreeSize
+		return nil
+	}
+	for _, r := range ranges {
+		for leafIndex != r.Start {
+			if err := consume(uint64(nextSubtreeSize(leafIndex, r.Start))); err != nil {
+				return nil, err
+			}
+		}
+		for leafIndex != r.End {
+			subtreeSize := nextSubtreeSize(leafIndex, r.End)
+			if err := h.Skip(subtreeSize); err != nil {
+				return nil, err
+			}
+			leafIndex += uint64(subtreeSize)
+		}
+	}
+	for leafIndex != numLeaves {
+		if err := consume(tailSubtreeSize(leafIndex, numLeaves)); err != nil {
+			return nil, err
+		}
+	}
+	return proof, nil
+}
+
+// VerifyMultiRangeProofWithSize verifies a proof produced by
+// BuildMultiRangeProof or BuildMultiRangeProofWithSize for a tree containing
+// numLeaves leaves, using leaf hashes produced by lh, which must contain the
+// concatenation of the leaf hashes within the proof ranges. Unlike
+// VerifyMultiRangeProof, the number of proof hashes and the height of the
+// subtree that each of them covers are determined by numLeaves, and the
+// proof is rejected with ErrProofTooShort or ErrProofTooLong if it does not
+// contain exactly that number of hashes. A proof for an empty set of ranges
+// is never valid. If the ranges are invalid, ErrInvalidRange,
+// ErrUnsortedRanges, or ErrRangeOutOfBounds is returned.
+func VerifyMultiRangeProofWithSize(lh LeafHasher, h hash.Hash, ranges []LeafRange, proof [][]byte, root []byte, numLeaves uint64) (bool, error) {
+	if err := checkRangesWithin(ranges, numLeaves); err != nil {
+		return false, err
+	}
+	if len(ranges) == 0 {
+		// a proof without ranges does not authenticate any leaves
+		return false, nil
+	}
+
+	// manually build a tree using the proof hashes
+	tree := New(h)
+	var leafIndex uint64
+	push := func(subtreeSize uint64) error {
+		if len(proof) == 0 {
+			return ErrProofTooShort
+		}
+		// An incomplete subtree at the end of the tree is pushed with the
+		// height of its largest complete subtree, which is smaller than the
+		// height of any subtree before it.
+		if err := tree.PushSubTree(bits.Len64(subtreeSize)-1, proof[0]); err != nil {
+			return err
+		}
+		proof = proof[1:]
+		leafIndex += subtreeSize
+		return nil
+	}
+
+	for _, r := range ranges {
+		// add proof hashes from leaves [leafIndex, r.Start)
+		for leafIndex != r.Start {
+			if err := push(uint64(nextSubtreeSize(leafIndex, r.Start))); err != nil {
+				return false, err
+			}
+		}
+		// add leaf hashes within the proof range
+		for i := r.Start; i < r.End; i++ {
+			leafHash, err := lh.NextLeafHash()
+			if err != nil {
+				return false, err
+			}
+			if err := tree.PushSubTree(0, leafHash); err != nil {
+				panic(err)
+			}
+		}
+		leafIndex += r.End - r.Start
+	}
+
+	// add remaining proof hashes after the last range ends
+	for leafIndex != numLeaves {
+		if err := push(tailSubtreeSize(leafIndex, numLeaves)); err != nil {
+			return false, err
+		}
+	}
+	if len(proof) != 0 {
+		return false, ErrProofTooLong
+	}
+
+	return bytes.Equal(tree.Root(), root), nil
+}
+
 // proofMapping returns an index-to-index mapping that maps a hash's index in
 // a "new" proof (produced by BuildRangeProof) to its index in an "old" proof
 // (produced by (*Tree).Prove), i.e. new[i] = old[m[i]].
@@ -7,6 +7,7 @@ import (
 	"hash"
 	"io"
 	"reflect"
+	"runtime"
 	"testing"
 
 	"github.com/uplo-tech/fastrand"
@@ -517,6 +518,94 @@ func TestBuildVerifyMultiRangeProof(t *testing.T) {
 	}
 }
 
+// TestMultiRangeProofWithSize tests the BuildMultiRangeProofWithSize and
+// VerifyMultiRangeProofWithSize functions.
+func TestMultiRangeProofWithSize(t *testing.T) {
+	const leafSize = 8
+	blake, _ := blake2b.New256(nil)
+	leafData := fastrand.Bytes(16 * leafSize)
+	leafHashes := make([][]byte, 16)
+	for i := range leafHashes {
+		leafHashes[i] = leafSum(blake, leafData[i*leafSize:][:leafSize])
+	}
+
+	var allRangeSets func(min, max uint64) [][]LeafRange
+	allRangeSets = func(min, max uint64) [][]LeafRange {
+		var all [][]LeafRange
+		for i := min; i < max; i++ {
+			for j := i + 1; j <= max; j++ {
+				all = append(all, []LeafRange{{i, j}})
+				for _, sub := range allRangeSets(j, max) {
+					withPrefix := append([]LeafRange{{i, j}}, sub...)
+					all = append(all, withPrefix)
+				}
+			}
+		}
+		return all
+	}
+	for numLeaves := uint64(1); numLeaves <= 7; numLeaves++ {
+		root := bytesRoot(leafData[:numLeaves*leafSize], blake, leafSize)
+		for _, ranges := range allRangeSets(0, numLeaves) {
+			proof, err := BuildMultiRangeProofWithSize(ranges, NewCachedSubtreeHasher(leafHashes[:numLeaves], blake), numLeaves)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expProof, _ := BuildMultiRangeProof(ranges, NewCachedSubtreeHasher(leafHashes[:numLeaves], blake))
+			if !reflect.DeepEqual(proof, expProof) {
+				t.Fatalf("proof for ranges %v differs from BuildMultiRangeProof", ranges)
+			}
+			var hashes [][]byte
+			for _, r := range ranges {
+				hashes = append(hashes, leafHashes[r.Start:r.End]...)
+			}
+			ok, err := VerifyMultiRangeProofWithSize(NewCachedLeafHasher(hashes), blake, ranges, proof, root, numLeaves)
+			if err != nil {
+				t.Fatal(err)
+			} else if !ok {
+				t.Fatalf("failed to verify proof for ranges %v of %v leaves", ranges, numLeaves)
+			}
+
+			// The proof must be rejected for any size with a different
+			// layout. Sizes with the same layout produce the same root,
+			// since the proof hashes cover the same leaves.
+			for claimed := uint64(0); claimed <= 16; claimed++ {
+				if claimed == numLeaves {
+					continue
+				}
+				if claimed >= ranges[len(ranges)-1].End {
+					layout, _ := BuildMultiRangeProofWithSize(ranges, NewCachedSubtreeHasher(leafHashes[:claimed], blake), claimed)
+					if len(layout) == len(proof) {
+						continue
+					}
+				}
+				// the proof is either the wrong length, or produces the
+				// wrong root
+				if ok, _ := VerifyMultiRangeProofWithSize(NewCachedLeafHasher(hashes), blake, ranges, proof, root, claimed); ok {
+					t.Fatalf("proof for ranges %v of %v leaves verified for %v leaves", ranges, numLeaves, claimed)
+				}
+			}
+		}
+	}
+
+	// extra proof hashes should be rejected
+	ranges := []LeafRange{{3, 5}}
+	proof, _ := BuildMultiRangeProofWithSize(ranges, NewCachedSubtreeHasher(leafHashes[:10], blake), 10)
+	root := bytesRoot(leafData[:10*leafSize], blake, leafSize)
+	if ok, err := VerifyMultiRangeProofWithSize(NewCachedLeafHasher(leafHashes[3:5]), blake, ranges, append(proof, proof[0]), root, 10); ok || err != ErrProofTooLong {
+		t.Fatal("expected ErrProofTooLong, got", err)
+	}
+
+	// a proof without ranges should not verify against any root
+	if ok, err := VerifyMultiRangeProofWithSize(NewCachedLeafHasher(nil), blake, nil, nil, []byte("x"), 0); ok {
+		t.Fatal("proof without ranges was accepted, error:", err)
+	}
+
+	// a SubtreeHasher with too few leaves should fail
+	if _, err := BuildMultiRangeProofWithSize(ranges, NewCachedSubtreeHasher(leafHashes[:8], blake), 10); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", err)
+	}
+}
+
 // TestBuildVerifyRangeProof tests the BuildRangeProof and VerifyRangeProof
 // functions.
 func TestBuildVerifyRangeProof(t *testing.T) {
@@ -766,10 +855,16 @@ func TestBuildProofRangeEOF(t *testing.T) {
 	// BuildRangeProof tries to skip over the proof range.
 	midl, midr := numLeaves/2-1, numLeaves/2+1
 
-	// test with both ReaderSubtreeHasher and CachedSubtreeHasher
+	newBlake := func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	}
+	// test with ReaderSubtreeHasher, CachedSubtreeHasher and the ReaderAt hashers
 	shs := []SubtreeHasher{
 		NewReaderSubtreeHasher(bytes.NewReader(leafData[:len(leafData)/2]), leafSize, blake),
 		NewCachedSubtreeHasher(leafHashes[:len(leafHashes)/2], blake),
+		NewReaderAtSubtreeHasher(bytes.NewReader(leafData[:len(leafData)/2]), int64(len(leafData)/2), leafSize, blake),
+		NewParallelReaderAtSubtreeHasher(bytes.NewReader(leafData[:len(leafData)/2]), int64(len(leafData)/2), leafSize, newBlake, []LeafRange{{uint64(midl), uint64(midr)}}, 0),
 	}
 	for _, sh := range shs {
 		if _, err := BuildRangeProof(midl, midr, sh); err != io.ErrUnexpectedEOF {
@@ -778,6 +873,119 @@ func TestBuildProofRangeEOF(t *testing.T) {
 	}
 }
 
+// TestReaderAtSubtreeHasher tests that a ReaderAtSubtreeHasher produces the
+// same proofs as a ReaderSubtreeHasher, while only reading the leaves that
+// are not skipped or covered by cached roots.
+func TestReaderAtSubtreeHasher(t *testing.T) {
+	const leafSize = 64
+	const leavesPerNode = 16
+	blake, _ := blake2b.New256(nil)
+	for _, numLeaves := range []int{1, 7, 16, 100, 257, 1000} {
+		// the last leaf is sometimes partial
+		data := fastrand.Bytes(numLeaves*leafSize - fastrand.Intn(leafSize))
+		var nodeHashes [][]byte
+		for i := 0; i < len(data); i += leavesPerNode * leafSize {
+			end := i + leavesPerNode*leafSize
+			if end > len(data) {
+				end = len(data)
+			}
+			nodeHashes = append(nodeHashes, bytesRoot(data[i:end], blake, leafSize))
+		}
+
+		for n := 0; n < 10; n++ {
+			start := fastrand.Intn(numLeaves)
+			end := start + 1 + fastrand.Intn(numLeaves-start)
+			ranges := []LeafRange{{uint64(start), uint64(end)}}
+			rangeData := data[start*leafSize:]
+			if len(rangeData) > (end-start)*leafSize {
+				rangeData = rangeData[:(end-start)*leafSize]
+			}
+			expProof, expErr := BuildMultiRangeProof(ranges, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, blake))
+
+			// like ReaderSubtreeHasher, the hasher can't skip the partial
+			// final leaf
+			r := &countingReaderAt{r: bytes.NewReader(data)}
+			proof, err := BuildMultiRangeProof(ranges, NewReaderAtSubtreeHasher(r, int64(len(data)), leafSize, blake))
+			if err != expErr {
+				t.Fatalf("expected error %v, got %v", expErr, err)
+			} else if err != nil {
+				_, err = BuildMultiRangeProof(ranges, NewMixedReaderAtSubtreeHasher(nodeHashes, r, int64(len(data)), leavesPerNode, leafSize, blake))
+				if err != expErr {
+					t.Fatalf("expected error %v from mixed ReaderAtSubtreeHasher, got %v", expErr, err)
+				}
+				continue
+			} else if !reflect.DeepEqual(proof, expProof) {
+				t.Fatal("ReaderAtSubtreeHasher produced the wrong proof")
+			} else if r.bytes != int64(len(data)-len(rangeData)) {
+				t.Fatal("ReaderAtSubtreeHasher read the leaves of the proof range")
+			}
+
+			r = &countingReaderAt{r: bytes.NewReader(data)}
+			proof, err = BuildMultiRangeProof(ranges, NewMixedReaderAtSubtreeHasher(nodeHashes, r, int64(len(data)), leavesPerNode, leafSize, blake))
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(proof, expProof) {
+				t.Fatal("mixed ReaderAtSubtreeHasher produced the wrong proof")
+			} else if r.bytes > 4*leavesPerNode*leafSize {
+				t.Fatalf("mixed ReaderAtSubtreeHasher read %v bytes", r.bytes)
+			}
+		}
+	}
+
+	// as with ReaderSubtreeHasher, skipping past the end of the data is an
+	// error, and so is skipping the partial final leaf, but the partial leaf
+	// can be hashed
+	sh := NewReaderAtSubtreeHasher(bytes.NewReader(make([]byte, 100)), 100, leafSize, blake)
+	if err := sh.Skip(3); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", err)
+	} else if err := sh.Skip(2); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", err)
+	} else if err := sh.Skip(1); err != nil {
+		t.Fatal(err)
+	} else if root, err := sh.NextSubtreeRoot(1); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(root, leafSum(blake, make([]byte, 100-leafSize))) {
+		t.Fatal("wrong root for the partial final leaf")
+	} else if _, err := sh.NextSubtreeRoot(1); err != io.EOF {
+		t.Fatal("expected io.EOF, got", err)
+	}
+}
+
+// TestReaderAtSubtreeHasherMemory tests that a ReaderAtSubtreeHasher reads
+// large subtrees in bounded chunks, rather than allocating a buffer for the
+// whole subtree.
+func TestReaderAtSubtreeHasherMemory(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	const leafSize = 4096
+	blake, _ := blake2b.New256(nil)
+	data := fastrand.Bytes(1<<26 - 100)
+	numLeaves := (len(data) + leafSize - 1) / leafSize
+	buildProof := func(sh SubtreeHasher) ([][]byte, uint64) {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		proof, err := BuildRangeProof(numLeaves-2, numLeaves-1, sh)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return proof, after.TotalAlloc - before.TotalAlloc
+	}
+
+	// the proof consists of subtrees of up to 32 MiB and the partial final
+	// leaf, but apart from the allocations made while hashing each leaf,
+	// which a ReaderSubtreeHasher makes as well, no more than a chunk should
+	// be allocated
+	expProof, expAlloc := buildProof(NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, blake))
+	proof, alloc := buildProof(NewReaderAtSubtreeHasher(bytes.NewReader(data), int64(len(data)), leafSize, blake))
+	if !reflect.DeepEqual(proof, expProof) {
+		t.Fatal("ReaderAtSubtreeHasher produced the wrong proof")
+	} else if alloc > expAlloc+2*parallelChunkSize {
+		t.Fatalf("ReaderAtSubtreeHasher allocated %v bytes, ReaderSubtreeHasher %v", alloc, expAlloc)
+	}
+}
+
 // TestProofConversion tests that "old" single-leaf Merkle proofs can be
 // converted into "new" single-leaf Merkle range proofs, and vice versa.
 func TestProofConversion(t *testing.T) {
@@ -1166,6 +1374,185 @@ func TestBuildVerifyDiffProof(t *testing.T) {
 	}
 }
 
+// TestUpdateRootWithDiffProof tests that UpdateRootWithDiffProof computes the
+// root of a tree after the leaves within a set of ranges have been modified.
+func TestUpdateRootWithDiffProof(t *testing.T) {
+	const leafSize = 64
+	const numLeaves = 37
+	blake, _ := blake2b.New256(nil)
+	oldData := fastrand.Bytes(numLeaves * leafSize)
+	oldRoot := bytesRoot(oldData, blake, leafSize)
+
+	for _, ranges := range [][]LeafRange{
+		{{0, 1}},
+		{{36, 37}},
+		{{0, 37}},
+		{{3, 9}, {16, 17}, {20, 32}},
+	} {
+		newData := append([]byte(nil), oldData...)
+		for _, r := range ranges {
+			fastrand.Read(newData[r.Start*leafSize : r.End*leafSize])
+		}
+		proof, err := BuildDiffProof(ranges, NewReaderSubtreeHasher(bytes.NewReader(oldData), leafSize, blake), numLeaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rangeHashes := func(data []byte) [][]byte {
+			var rs []io.Reader
+			for _, r := range ranges {
+				rs = append(rs, bytes.NewReader(data[r.Start*leafSize:r.End*leafSize]))
+			}
+			hashes, err := CompressLeafHashes(ranges, NewReaderSubtreeHasher(io.MultiReader(rs...), leafSize, blake))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return hashes
+		}
+		oldHashes, newHashes := rangeHashes(oldData), rangeHashes(newData)
+
+		newRoot, err := UpdateRootWithDiffProof(oldHashes, newHashes, numLeaves, blake, ranges, proof, oldRoot)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(newRoot, bytesRoot(newData, blake, leafSize)) {
+			t.Fatalf("wrong new root for ranges %v", ranges)
+		}
+
+		// the old hashes must match the old root
+		if _, err := UpdateRootWithDiffProof(newHashes, oldHashes, numLeaves, blake, ranges, proof, oldRoot); err == nil {
+			t.Fatal("expected error when old hashes don't match the old root")
+		}
+		if _, err := UpdateRootWithDiffProof(oldHashes, newHashes[1:], numLeaves, blake, ranges, proof, oldRoot); err != ErrProofTooShort {
+			t.Fatal("expected ErrProofTooShort for too few new hashes, got", err)
+		}
+		if _, err := UpdateRootWithDiffProof(oldHashes, append(newHashes, newHashes[0]), numLeaves, blake, ranges, proof, oldRoot); err != ErrProofTooLong {
+			t.Fatal("expected ErrProofTooLong for too many new hashes, got", err)
+		}
+
+		// the proof must contain exactly the hashes the tree requires
+		if len(proof) > 0 {
+			if _, err := UpdateRootWithDiffProof(oldHashes, newHashes, numLeaves, blake, ranges, proof[:len(proof)-1], oldRoot); err != ErrProofTooShort {
+				t.Fatal("expected ErrProofTooShort for a truncated proof, got", err)
+			}
+		}
+		if _, err := UpdateRootWithDiffProof(oldHashes, newHashes, numLeaves, blake, ranges, append(proof, oldRoot), oldRoot); err != ErrProofTooLong {
+			t.Fatal("expected ErrProofTooLong for an extended proof, got", err)
+		}
+	}
+
+	// the old root alone is not a proof that the old root can be replaced
+	// with itself
+	ranges := []LeafRange{{0, 2}}
+	if _, err := UpdateRootWithDiffProof(nil, nil, 4, blake, ranges, [][]byte{oldRoot}, oldRoot); err != ErrProofTooShort {
+		t.Fatal("expected ErrProofTooShort, got", err)
+	}
+}
+
+// TestResizeDiffProof tests that diff proofs can prove modifications that
+// append leaves to a tree or cut leaves off it.
+func TestResizeDiffProof(t *testing.T) {
+	const leafSize = 64
+	blake, _ := blake2b.New256(nil)
+	rangeHashes := func(data []byte, ranges []LeafRange) [][]byte {
+		var rs []io.Reader
+		for _, r := range ranges {
+			rs = append(rs, bytes.NewReader(data[r.Start*leafSize:r.End*leafSize]))
+		}
+		hashes, err := CompressLeafHashes(ranges, NewReaderSubtreeHasher(io.MultiReader(rs...), leafSize, blake))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hashes
+	}
+
+	tests := []struct {
+		oldNumLeaves uint64
+		newNumLeaves uint64
+		ranges       []LeafRange
+	}{
+		{15, 15, []LeafRange{{3, 4}}},
+		{15, 17, []LeafRange{{3, 4}}},
+		{15, 20, nil},
+		{15, 16, []LeafRange{{14, 15}}},
+		{15, 13, []LeafRange{{3, 4}}},
+		{16, 3, []LeafRange{{0, 1}}},
+		{15, 8, []LeafRange{{0, 8}}},
+		{15, 0, nil},
+		{0, 5, nil},
+		{37, 64, []LeafRange{{0, 2}, {5, 9}, {36, 37}}},
+		{64, 37, []LeafRange{{0, 2}, {5, 9}, {35, 37}}},
+	}
+	for _, test := range tests {
+		oldData := fastrand.Bytes(int(test.oldNumLeaves) * leafSize)
+		oldRoot := bytesRoot(oldData, blake, leafSize)
+		newData := make([]byte, test.newNumLeaves*leafSize)
+		copy(newData, oldData)
+		for _, r := range test.ranges {
+			fastrand.Read(newData[r.Start*leafSize : r.End*leafSize])
+		}
+		if test.newNumLeaves > test.oldNumLeaves {
+			fastrand.Read(newData[test.oldNumLeaves*leafSize:])
+		}
+
+		proof, err := BuildResizeDiffProof(test.ranges, NewReaderSubtreeHasher(bytes.NewReader(oldData), leafSize, blake), test.oldNumLeaves, test.newNumLeaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.oldNumLeaves == test.newNumLeaves {
+			diffProof, _ := BuildDiffProof(test.ranges, NewReaderSubtreeHasher(bytes.NewReader(oldData), leafSize, blake), test.oldNumLeaves)
+			if !reflect.DeepEqual(proof, diffProof) {
+				t.Fatal("proof should be identical to BuildDiffProof if the size does not change")
+			}
+		}
+
+		oldHashes := rangeHashes(oldData, test.ranges)
+		newRanges := test.ranges
+		if test.newNumLeaves > test.oldNumLeaves {
+			newRanges = append(newRanges, LeafRange{test.oldNumLeaves, test.newNumLeaves})
+		}
+		newHashes := rangeHashes(newData, newRanges)
+		newRoot, err := VerifyResizeDiffProof(oldHashes, newHashes, test.oldNumLeaves, test.newNumLeaves, blake, test.ranges, proof, oldRoot)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(newRoot, bytesRoot(newData, blake, leafSize)) {
+			t.Fatalf("wrong new root for %v -> %v leaves, ranges %v", test.oldNumLeaves, test.newNumLeaves, test.ranges)
+		}
+
+		// the proof must match the old root
+		if test.oldNumLeaves > 0 {
+			badRoot := append([]byte(nil), oldRoot...)
+			badRoot[0] ^= 1
+			if _, err := VerifyResizeDiffProof(oldHashes, newHashes, test.oldNumLeaves, test.newNumLeaves, blake, test.ranges, proof, badRoot); err == nil {
+				t.Fatal("expected error when verifying against the wrong old root")
+			}
+		}
+		if len(proof) > 0 {
+			if _, err := VerifyResizeDiffProof(oldHashes, newHashes, test.oldNumLeaves, test.newNumLeaves, blake, test.ranges, proof[:len(proof)-1], oldRoot); err != ErrProofTooShort {
+				t.Fatal("expected ErrProofTooShort for a truncated proof, got", err)
+			}
+		}
+		if _, err := VerifyResizeDiffProof(oldHashes, newHashes, test.oldNumLeaves, test.newNumLeaves, blake, test.ranges, append(proof, oldRoot), oldRoot); err != ErrProofTooLong {
+			t.Fatal("expected ErrProofTooLong for an extended proof, got", err)
+		}
+
+		// the new hashes must cover the modified and appended leaves
+		if len(newHashes) > 0 {
+			if _, err := VerifyResizeDiffProof(oldHashes, newHashes[:len(newHashes)-1], test.oldNumLeaves, test.newNumLeaves, blake, test.ranges, proof, oldRoot); err != ErrProofTooShort {
+				t.Fatal("expected ErrProofTooShort for too few new hashes, got", err)
+			}
+		}
+		if _, err := VerifyResizeDiffProof(oldHashes, append(newHashes, oldRoot), test.oldNumLeaves, test.newNumLeaves, blake, test.ranges, proof, oldRoot); err != ErrProofTooLong {
+			t.Fatal("expected ErrProofTooLong for too many new hashes, got", err)
+		}
+	}
+
+	// reaching the end of the data before the end of the old leaves should
+	// fail
+	data := fastrand.Bytes(8 * leafSize)
+	if _, err := BuildResizeDiffProof(nil, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, blake), 12, 4); err != io.ErrUnexpectedEOF {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", err)
+	}
+}
+
 // TestProofOfModification uses diff proofs to prove arbitrary modifications to
 // a Merkle tree.
 func TestProofOfModification(t *testing.T) {
@@ -11,8 +11,13 @@ import (
 // padding is added to the data, so the last element may be smaller than
 // 'segmentSize'.
 func (t *Tree) ReadAll(r io.Reader, segmentSize int) error {
+	// The segment buffer is reused for every leaf, since Push does not
+	// retain the data it is given.
+	if cap(t.segment) < segmentSize {
+		t.segment = make([]byte, segmentSize)
+	}
 	for {
-		segment := make([]byte, segmentSize)
+		segment := t.segment[:segmentSize]
 		n, readErr := io.ReadFull(r, segment)
 		if readErr == io.EOF {
 			// All data has been read.
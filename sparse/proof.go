@@ -0,0 +1,117 @@
+package sparse
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// Prove returns a proof for the leaf of the key: the hashes of the siblings
+// of the nodes on the path from the leaf to the root, ordered from the leaf
+// to the root. If the key is in the tree, the proof can be verified with
+// VerifyInclusionProof; otherwise it can be verified with
+// VerifyNonInclusionProof.
+func (t *Tree) Prove(key [KeySize]byte) [][]byte {
+	proof := make([][]byte, Depth)
+	for height := range proof {
+		proof[height] = append([]byte(nil), t.node(sibling(key, height))...)
+	}
+	return proof
+}
+
+// ProveCompressed returns the same proof as Prove, with the empty sibling
+// hashes removed.
+func (t *Tree) ProveCompressed(key [KeySize]byte) CompressedProof {
+	return CompressProof(t.Prove(key))
+}
+
+// verifyProof checks that the root of the tree containing the leaf hash at
+// the position of the key matches the specified root.
+func verifyProof(h hash.Hash, root []byte, key [KeySize]byte, leaf []byte, proof [][]byte) bool {
+	if len(proof) != Depth {
+		return false
+	}
+	// Siblings of any other size would make it possible to move bytes
+	// between the children of a node without changing its hash.
+	for _, s := range proof {
+		if len(s) != h.Size() {
+			return false
+		}
+	}
+	empty := make([]byte, h.Size())
+	return bytes.Equal(rootFromLeaf(h, empty, key, leaf, proof), root)
+}
+
+// VerifyInclusionProof verifies a proof produced by Prove, showing that the
+// key has the specified value in the tree with the specified root.
+func VerifyInclusionProof(h hash.Hash, root []byte, key [KeySize]byte, value []byte, proof [][]byte) bool {
+	return verifyProof(h, root, key, leafSum(h, key, value), proof)
+}
+
+// VerifyNonInclusionProof verifies a proof produced by Prove, showing that
+// the key is not in the tree with the specified root.
+func VerifyNonInclusionProof(h hash.Hash, root []byte, key [KeySize]byte, proof [][]byte) bool {
+	return verifyProof(h, root, key, make([]byte, h.Size()), proof)
+}
+
+// A CompressedProof is a proof from which the empty sibling hashes have been
+// removed. In a tree with n keys, all but about log2(n) of the siblings on
+// the path to a leaf are usually empty.
+type CompressedProof struct {
+	// Bitmap has a bit for every sibling of the full proof, in the same
+	// order; bit i is (Bitmap[i/8] >> (i%8)) & 1. The bit is set if the
+	// sibling is not empty.
+	Bitmap [Depth / 8]byte
+	// Siblings holds the siblings that are not empty.
+	Siblings [][]byte
+}
+
+// CompressProof removes the empty sibling hashes from a proof produced by
+// Prove.
+func CompressProof(proof [][]byte) CompressedProof {
+	var cp CompressedProof
+	for i, s := range proof {
+		if !isEmpty(s) {
+			cp.Bitmap[i/8] |= 1 << uint(i%8)
+			cp.Siblings = append(cp.Siblings, s)
+		}
+	}
+	return cp
+}
+
+// DecompressProof restores the full proof from a compressed proof, using the
+// hash size of the tree for the empty siblings.
+func DecompressProof(cp CompressedProof, hashSize int) ([][]byte, error) {
+	var numSiblings int
+	for _, b := range cp.Bitmap {
+		numSiblings += bits.OnesCount8(b)
+	}
+	if numSiblings != len(cp.Siblings) {
+		return nil, errors.New("number of siblings does not match the bitmap")
+	}
+	proof := make([][]byte, Depth)
+	siblings := cp.Siblings
+	for i := range proof {
+		if cp.Bitmap[i/8]&(1<<uint(i%8)) == 0 {
+			proof[i] = make([]byte, hashSize)
+			continue
+		}
+		if len(siblings[0]) != hashSize {
+			return nil, errors.New("sibling has the wrong size")
+		}
+		proof[i] = siblings[0]
+		siblings = siblings[1:]
+	}
+	return proof, nil
+}
+
+// isEmpty reports whether a hash is the all-zero hash of an empty subtree.
+func isEmpty(sum []byte) bool {
+	for _, b := range sum {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+// Package sparse provides a sparse Merkle tree, which commits to a mapping
+// from 256-bit keys to values. Every possible key has a leaf in the tree, at
+// the position given by the bits of the key, and the leaves of keys that are
+// not in the mapping are empty. This allows proving both that a key has a
+// certain value and that a key is not in the mapping.
+//
+// The tree uses the same domain separation as package merkletree: a leaf is
+// hashed as Hash(0x00 || key || value), and a node as Hash(0x01 || left
+// child || right child). An empty subtree has the all-zero hash, and a node
+// whose children are both empty is empty itself, so that the tree can be
+// stored and proven without computing hashes for the empty parts of the tree.
+// Package merkletree-blake/sparse provides the same tree using BLAKE2b.
+package sparse
+
+import (
+	"bytes"
+	"hash"
+)
+
+// KeySize is the size of a key in bytes. Keys of other sizes, or keys that
+// are not uniformly distributed, should be hashed before being used as keys.
+const KeySize = 32
+
+// Depth is the number of levels below the root of the tree.
+const Depth = KeySize * 8
+
+var (
+	// prefixes used during hashing, the same as those of package merkletree
+	leafHashPrefix = []byte{0x00}
+	nodeHashPrefix = []byte{0x01}
+)
+
+// A nodeID identifies a node of the tree by its height above the leaves and
+// the bits of the path leading to it, i.e. a key whose lowest 'height' bits
+// are zero.
+type nodeID struct {
+	height int
+	path   [KeySize]byte
+}
+
+// bit returns the bit of the key that determines whether the node at the
+// specified height on the path to the key's leaf is a left (0) or right (1)
+// child.
+func bit(key [KeySize]byte, height int) int {
+	i := Depth - 1 - height
+	return int(key[i/8]>>(7-uint(i%8))) & 1
+}
+
+// ancestor returns the ID of the node at the specified height on the path to
+// the key's leaf.
+func ancestor(key [KeySize]byte, height int) nodeID {
+	for i := Depth - height; i < Depth; i++ {
+		key[i/8] &^= 1 << (7 - uint(i%8))
+	}
+	return nodeID{height: height, path: key}
+}
+
+// sibling returns the ID of the sibling of the node at the specified height
+// on the path to the key's leaf.
+func sibling(key [KeySize]byte, height int) nodeID {
+	id := ancestor(key, height)
+	i := Depth - 1 - height
+	id.path[i/8] ^= 1 << (7 - uint(i%8))
+	return id
+}
+
+// A Tree is a sparse Merkle tree. Only the non-empty nodes of the tree are
+// stored, so the memory used by the tree grows with the number of keys.
+type Tree struct {
+	hash   hash.Hash
+	empty  []byte
+	root   []byte
+	nodes  map[nodeID][]byte
+	values map[[KeySize]byte][]byte
+}
+
+// New creates a new, empty Tree. The provided hash will be used for all
+// hashing operations within the Tree.
+func New(h hash.Hash) *Tree {
+	empty := make([]byte, h.Size())
+	return &Tree{
+		hash:   h,
+		empty:  empty,
+		root:   empty,
+		nodes:  make(map[nodeID][]byte),
+		values: make(map[[KeySize]byte][]byte),
+	}
+}
+
+// sum returns the hash of the input data using the specified algorithm.
+func sum(h hash.Hash, data ...[]byte) []byte {
+	h.Reset()
+	for _, d := range data {
+		// the Hash interface specifies that Write never returns an error
+		_, _ = h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// leafSum returns the hash of the leaf of a key with the specified value.
+func leafSum(h hash.Hash, key [KeySize]byte, value []byte) []byte {
+	return sum(h, leafHashPrefix, key[:], value)
+}
+
+// nodeSum returns the hash of a node with the specified children. A node
+// whose children are both empty is empty itself.
+func nodeSum(h hash.Hash, empty, left, right []byte) []byte {
+	if bytes.Equal(left, empty) && bytes.Equal(right, empty) {
+		return empty
+	}
+	return sum(h, nodeHashPrefix, left, right)
+}
+
+// rootFromLeaf returns the root of the tree that contains the leaf hash at
+// the position of the key, given the sibling hashes along the path to the
+// root, ordered from the leaf to the root.
+func rootFromLeaf(h hash.Hash, empty []byte, key [KeySize]byte, leaf []byte, siblings [][]byte) []byte {
+	current := leaf
+	for height, s := range siblings {
+		if bit(key, height) == 0 {
+			current = nodeSum(h, empty, current, s)
+		} else {
+			current = nodeSum(h, empty, s, current)
+		}
+	}
+	return current
+}
+
+// setLeaf sets the hash of the key's leaf and recomputes the nodes on the
+// path to the root.
+func (t *Tree) setLeaf(key [KeySize]byte, leaf []byte) {
+	current := leaf
+	for height := 0; height < Depth; height++ {
+		if bytes.Equal(current, t.empty) {
+			delete(t.nodes, ancestor(key, height))
+		} else {
+			t.nodes[ancestor(key, height)] = current
+		}
+		s := t.node(sibling(key, height))
+		if bit(key, height) == 0 {
+			current = nodeSum(t.hash, t.empty, current, s)
+		} else {
+			current = nodeSum(t.hash, t.empty, s, current)
+		}
+	}
+	t.root = current
+}
+
+// node returns the hash of the node with the specified ID.
+func (t *Tree) node(id nodeID) []byte {
+	if sum, ok := t.nodes[id]; ok {
+		return sum
+	}
+	return t.empty
+}
+
+// Update sets the value of the key. The value is copied.
+func (t *Tree) Update(key [KeySize]byte, value []byte) {
+	value = append([]byte{}, value...)
+	t.values[key] = value
+	t.setLeaf(key, leafSum(t.hash, key, value))
+}
+
+// Delete removes the key from the tree, leaving its leaf empty. Deleting a
+// key that is not in the tree has no effect.
+func (t *Tree) Delete(key [KeySize]byte) {
+	if _, ok := t.values[key]; !ok {
+		return
+	}
+	delete(t.values, key)
+	t.setLeaf(key, t.empty)
+}
+
+// Get returns the value of the key, and whether the key is in the tree.
+func (t *Tree) Get(key [KeySize]byte) ([]byte, bool) {
+	value, ok := t.values[key]
+	return value, ok
+}
+
+// Root returns the Merkle root of the tree. The root of an empty tree is the
+// all-zero hash.
+func (t *Tree) Root() []byte {
+	return append([]byte(nil), t.root...)
+}
@@ -0,0 +1,120 @@
+package sparse
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// randomKey returns a random key.
+func randomKey() (key [KeySize]byte) {
+	fastrand.Read(key[:])
+	return
+}
+
+// referenceRoot computes the root of a tree containing the specified keys
+// and values by recursively splitting the keys at each level of the tree.
+func referenceRoot(h hash.Hash, values map[[KeySize]byte][]byte) []byte {
+	empty := make([]byte, h.Size())
+	var root func(height int, keys [][KeySize]byte) []byte
+	root = func(height int, keys [][KeySize]byte) []byte {
+		if len(keys) == 0 {
+			return empty
+		} else if height == 0 {
+			return leafSum(h, keys[0], values[keys[0]])
+		}
+		var left, right [][KeySize]byte
+		for _, key := range keys {
+			if bit(key, height-1) == 0 {
+				left = append(left, key)
+			} else {
+				right = append(right, key)
+			}
+		}
+		return nodeSum(h, empty, root(height-1, left), root(height-1, right))
+	}
+	var keys [][KeySize]byte
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return root(Depth, keys)
+}
+
+// TestTreeRoot tests that the root of a Tree matches the reference
+// computation as keys are updated and deleted.
+func TestTreeRoot(t *testing.T) {
+	h := sha256.New()
+	tree := New(h)
+	if !bytes.Equal(tree.Root(), make([]byte, h.Size())) {
+		t.Fatal("root of an empty tree should be the zero hash")
+	}
+
+	values := make(map[[KeySize]byte][]byte)
+	var keys [][KeySize]byte
+	for i := 0; i < 20; i++ {
+		key := randomKey()
+		if i%5 == 4 {
+			// use a key that shares a long prefix with an existing key
+			key = keys[0]
+			key[KeySize-1] ^= 1
+		}
+		keys = append(keys, key)
+		values[key] = fastrand.Bytes(fastrand.Intn(10))
+		tree.Update(key, values[key])
+		if !bytes.Equal(tree.Root(), referenceRoot(h, values)) {
+			t.Fatalf("wrong root after inserting %v keys", len(values))
+		}
+	}
+
+	// updating an existing key changes the root
+	values[keys[3]] = []byte("foo")
+	tree.Update(keys[3], []byte("foo"))
+	if !bytes.Equal(tree.Root(), referenceRoot(h, values)) {
+		t.Fatal("wrong root after updating a key")
+	}
+	if value, ok := tree.Get(keys[3]); !ok || !bytes.Equal(value, []byte("foo")) {
+		t.Fatal("Get returned the wrong value")
+	}
+
+	// deleting keys removes their leaves, and deleting missing keys does
+	// nothing
+	for _, key := range keys {
+		root := tree.Root()
+		tree.Delete(randomKey())
+		if !bytes.Equal(tree.Root(), root) {
+			t.Fatal("deleting a missing key changed the root")
+		}
+		delete(values, key)
+		tree.Delete(key)
+		if !bytes.Equal(tree.Root(), referenceRoot(h, values)) {
+			t.Fatalf("wrong root after deleting down to %v keys", len(values))
+		}
+		if _, ok := tree.Get(key); ok {
+			t.Fatal("deleted key is still in the tree")
+		}
+	}
+	if !bytes.Equal(tree.Root(), make([]byte, h.Size())) || len(tree.nodes) != 0 {
+		t.Fatal("tree should be empty after deleting all keys")
+	}
+}
+
+// TestTreeOrder tests that the root of a Tree does not depend on the order in
+// which the keys are inserted.
+func TestTreeOrder(t *testing.T) {
+	h := sha256.New()
+	keys := make([][KeySize]byte, 50)
+	for i := range keys {
+		keys[i] = randomKey()
+	}
+	a, b := New(h), New(h)
+	for i := range keys {
+		a.Update(keys[i], keys[i][:])
+		b.Update(keys[len(keys)-1-i], keys[len(keys)-1-i][:])
+	}
+	if !bytes.Equal(a.Root(), b.Root()) {
+		t.Fatal("trees with the same keys have different roots")
+	}
+}
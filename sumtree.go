@@ -0,0 +1,182 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// A sumHash wraps a hash.Hash so that every leaf and node hash of a Merkle
+// tree also commits to the sum of the values of the leaves in its subtree.
+// The hash of a leaf or node is
+//
+//	sum || Hash(input)
+//
+// where sum is a big-endian uint64. The value of a leaf is the first 8 bytes
+// of its data, and the sum of a node is the sum of its children. Since the
+// sums of the children are part of the input of a node, the sum of every node
+// is committed to by its parent.
+type sumHash struct {
+	hash hash.Hash
+	buf  []byte
+}
+
+// Write implements hash.Hash.
+func (sh *sumHash) Write(p []byte) (int, error) {
+	sh.buf = append(sh.buf, p...)
+	return len(p), nil
+}
+
+// Sum implements hash.Hash.
+func (sh *sumHash) Sum(b []byte) []byte {
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], sh.value())
+	b = append(b, value[:]...)
+	sh.hash.Reset()
+	// the Hash interface specifies that Write never returns an error
+	_, _ = sh.hash.Write(sh.buf)
+	return sh.hash.Sum(b)
+}
+
+// Reset implements hash.Hash.
+func (sh *sumHash) Reset() {
+	sh.buf = sh.buf[:0]
+}
+
+// Size implements hash.Hash.
+func (sh *sumHash) Size() int {
+	return 8 + sh.hash.Size()
+}
+
+// BlockSize implements hash.Hash.
+func (sh *sumHash) BlockSize() int {
+	return sh.hash.BlockSize()
+}
+
+// value returns the sum of the leaf or node whose hash input has been
+// written. The sum of a node wraps around on overflow; SumTree and
+// VerifySumProof reject sums that overflow. Input that is neither a leaf nor
+// a node has a sum of 0.
+func (sh *sumHash) value() uint64 {
+	size := sh.Size()
+	switch {
+	case len(sh.buf) == 1+2*size && sh.buf[0] == nodeHashPrefix[0]:
+		left, right := sh.buf[1:1+size], sh.buf[1+size:]
+		return binary.BigEndian.Uint64(left) + binary.BigEndian.Uint64(right)
+	case len(sh.buf) >= 9 && sh.buf[0] == leafHashPrefix[0]:
+		return binary.BigEndian.Uint64(sh.buf[1:])
+	default:
+		return 0
+	}
+}
+
+// sumLeafData returns the data that a SumTree hashes for a leaf with the
+// specified value and data.
+func sumLeafData(value uint64, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(b, value)
+	return append(b, data...)
+}
+
+// A SumNode is an element of a SumTree proof. The first element of a proof
+// holds the data and value of the proven leaf; the others hold the hash of a
+// sibling subtree and the sum of the values in that subtree.
+type SumNode struct {
+	Data []byte
+	Sum  uint64
+}
+
+// A SumTree is a Merkle sum tree: a Tree whose leaves carry values, and whose
+// nodes commit to the sum of the values below them. A proof for a leaf shows
+// that the leaf is part of the tree and that its value contributes to the
+// total committed to by the root, while revealing only the sums of the
+// sibling subtrees. The shape of the tree, including the handling of the
+// subtrees at the end of the tree that are not full, is the same as that of a
+// Tree.
+type SumTree struct {
+	tree  *Tree
+	total uint64
+}
+
+// NewSumTree creates a new SumTree. The provided hash will be used for all
+// hashing operations within the SumTree.
+func NewSumTree(h hash.Hash) *SumTree {
+	return &SumTree{
+		tree: New(&sumHash{hash: h}),
+	}
+}
+
+// SetIndex will tell the SumTree to create a proof for the leaf at the input
+// index. SetIndex must be called on an empty tree.
+func (t *SumTree) SetIndex(i uint64) error {
+	return t.tree.SetIndex(i)
+}
+
+// Push adds a leaf with the specified value and data to the tree. It returns
+// an error if the total of the tree would overflow.
+func (t *SumTree) Push(value uint64, data []byte) error {
+	total, carry := bits.Add64(t.total, value, 0)
+	if carry != 0 {
+		return errors.New("total value of the tree overflows")
+	}
+	t.tree.Push(sumLeafData(value, data))
+	t.total = total
+	return nil
+}
+
+// Root returns the Merkle root of the tree and the total value of its leaves.
+// The root commits to the total. Root returns a nil root if the tree is
+// empty.
+func (t *SumTree) Root() (merkleRoot []byte, total uint64) {
+	root := t.tree.Root()
+	if root == nil {
+		return nil, 0
+	}
+	return root[8:], t.total
+}
+
+// Prove creates a proof that the leaf at the index established by SetIndex is
+// an element of the tree. The first element of the proof set holds the data
+// and value of the leaf. Prove returns a nil proof set if the leaf has not
+// been pushed yet.
+func (t *SumTree) Prove() (merkleRoot []byte, proofSet []SumNode, proofIndex uint64, numLeaves uint64) {
+	_, set, proofIndex, numLeaves := t.tree.Prove()
+	merkleRoot, _ = t.Root()
+	if len(set) == 0 {
+		return merkleRoot, nil, proofIndex, numLeaves
+	}
+	// Both the leaf data and the sibling hashes begin with their sum.
+	proofSet = make([]SumNode, len(set))
+	for i, s := range set {
+		proofSet[i] = SumNode{
+			Data: s[8:],
+			Sum:  binary.BigEndian.Uint64(s),
+		}
+	}
+	return merkleRoot, proofSet, proofIndex, numLeaves
+}
+
+// VerifySumProof verifies a proof produced by (*SumTree).Prove, showing that
+// the first element of the proof set is a leaf of the SumTree with the
+// specified root and total. It returns false if the sums in the proof set
+// overflow.
+func VerifySumProof(h hash.Hash, merkleRoot []byte, total uint64, proofSet []SumNode, proofIndex uint64, numLeaves uint64) bool {
+	if merkleRoot == nil || len(proofSet) == 0 {
+		return false
+	}
+	var proofTotal uint64
+	set := make([][]byte, len(proofSet))
+	for i, node := range proofSet {
+		var carry uint64
+		proofTotal, carry = bits.Add64(proofTotal, node.Sum, 0)
+		if carry != 0 {
+			return false
+		}
+		if i > 0 && len(node.Data) != h.Size() {
+			return false
+		}
+		set[i] = sumLeafData(node.Sum, node.Data)
+	}
+	return proofTotal == total && VerifyProof(&sumHash{hash: h}, sumLeafData(total, merkleRoot), set, proofIndex, numLeaves)
+}
@@ -0,0 +1,104 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestSumTree tests that proofs for every leaf of a SumTree can be verified,
+// and that proofs with altered sums are rejected.
+func TestSumTree(t *testing.T) {
+	h := sha256.New()
+	for numLeaves := uint64(1); numLeaves < 20; numLeaves++ {
+		values := make([]uint64, numLeaves)
+		data := make([][]byte, numLeaves)
+		var total uint64
+		for i := range values {
+			values[i] = uint64(fastrand.Intn(1000))
+			data[i] = fastrand.Bytes(8)
+			total += values[i]
+		}
+		for index := uint64(0); index < numLeaves; index++ {
+			tree := NewSumTree(h)
+			if err := tree.SetIndex(index); err != nil {
+				t.Fatal(err)
+			}
+			for i := range values {
+				if err := tree.Push(values[i], data[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			root, rootTotal := tree.Root()
+			if rootTotal != total {
+				t.Fatalf("expected total %v, got %v", total, rootTotal)
+			}
+			merkleRoot, proofSet, proofIndex, n := tree.Prove()
+			if !bytes.Equal(merkleRoot, root) || proofIndex != index || n != numLeaves {
+				t.Fatal("Prove returned the wrong root, index, or number of leaves")
+			} else if proofSet[0].Sum != values[index] || !bytes.Equal(proofSet[0].Data, data[index]) {
+				t.Fatal("first element of the proof set should be the proven leaf")
+			}
+			if !VerifySumProof(h, root, total, proofSet, index, numLeaves) {
+				t.Fatalf("proof for leaf %v of %v was rejected", index, numLeaves)
+			} else if VerifySumProof(h, root, total+1, proofSet, index, numLeaves) {
+				t.Fatal("proof verified with the wrong total")
+			}
+			// moving value between the leaf and a sibling keeps the total,
+			// but must change the root
+			if len(proofSet) > 1 && proofSet[0].Sum > 0 {
+				proofSet[0].Sum--
+				proofSet[1].Sum++
+				if VerifySumProof(h, root, total, proofSet, index, numLeaves) {
+					t.Fatal("proof verified with altered sums")
+				}
+			}
+		}
+	}
+}
+
+// TestSumTreeOverflow tests that a SumTree rejects leaves whose values
+// overflow the total, and that proofs whose sums overflow are rejected.
+func TestSumTreeOverflow(t *testing.T) {
+	h := sha256.New()
+	tree := NewSumTree(h)
+	if err := tree.SetIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Push(math.MaxUint64-1, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Push(2, []byte{1}); err == nil {
+		t.Fatal("expected error when the total overflows")
+	}
+	if err := tree.Push(1, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	root, total := tree.Root()
+	if total != math.MaxUint64 {
+		t.Fatal("wrong total:", total)
+	}
+	_, proofSet, _, _ := tree.Prove()
+	if !VerifySumProof(h, root, total, proofSet, 0, 2) {
+		t.Fatal("valid proof was rejected")
+	}
+
+	// a tree in which a sibling sum wraps around: the node sums match the
+	// wrapped total, but the proof must be rejected
+	st := &sumHash{hash: h}
+	leaf := leafSum(st, sumLeafData(5, []byte{0}))
+	sibling := leafSum(st, sumLeafData(math.MaxUint64, []byte{1}))
+	wrapped := nodeSum(st, leaf, sibling)
+	proofSet = []SumNode{{Data: []byte{0}, Sum: 5}, {Data: sibling[8:], Sum: math.MaxUint64}}
+	if VerifySumProof(h, wrapped[8:], 4, proofSet, 0, 2) {
+		t.Fatal("proof with overflowing sums was accepted")
+	}
+
+	empty := NewSumTree(h)
+	if root, total := empty.Root(); root != nil || total != 0 {
+		t.Fatal("expected nil root for an empty tree")
+	}
+}
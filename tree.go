@@ -19,8 +19,17 @@ type Tree struct {
 	// tree. When a new leaf is inserted, it is inserted as a subtree of height
 	// 0. If there is another subtree of the same height, both can be removed,
 	// combined, and then inserted as a subtree of height n + 1.
-	head *subTree
-	hash hash.Hash
+	//
+	// The stack is ordered from the largest subtree to the smallest. The sum
+	// buffers of popped subtrees are kept in the spare capacity of the stack
+	// and reused by later subtrees, so that pushing leaves does not allocate
+	// once the stack has reached its final height.
+	stack []subTree
+	hash  hash.Hash
+
+	// rootBuf and segment are scratch buffers used by Root and ReadAll.
+	rootBuf []byte
+	segment []byte
 
 	// Helper variables used to construct proofs that the data at 'proofIndex'
 	// is in the Merkle tree. The proofSet is constructed as elements are being
@@ -32,6 +41,18 @@ type Tree struct {
 	proofSet     [][]byte
 	proofTree    bool
 
+	// Helper variables used to construct a multi-range proof for the ranges
+	// set by SetRanges. rangeProof holds the roots of the subtrees between
+	// the ranges that have been completed so far. The next subtree that
+	// belongs in the proof is the subtree of height rangeHeight starting at
+	// leaf rangeLeaf, and rangeIndex is the index of the first range that
+	// has not yet been reached.
+	proofRanges []LeafRange
+	rangeIndex  int
+	rangeLeaf   uint64
+	rangeHeight int
+	rangeProof  [][]byte
+
 	// The cachedTree flag indicates that the tree is cached, meaning that
 	// different code is used in 'Push' for creating a new head subtree. Adding
 	// this flag is somewhat gross, but eliminates needing to duplicate the
@@ -40,22 +61,27 @@ type Tree struct {
 }
 
 // A subTree contains the Merkle root of a complete (2^height leaves) subTree
-// of the Tree. 'sum' is the Merkle root of the subTree. If 'next' is not nil,
-// it will be a tree with a higher height.
+// of the Tree. 'sum' is the Merkle root of the subTree.
 type subTree struct {
-	next   *subTree
 	height int // Int is okay because a height over 300 is physically unachievable.
 	sum    []byte
 }
 
 // sum returns the hash of the input data using the specified algorithm.
 func sum(h hash.Hash, data ...[]byte) []byte {
+	return appendSum(nil, h, data...)
+}
+
+// appendSum appends the hash of the input data to b and returns the resulting
+// slice. b may overlap with the input data, because all of the data is written
+// to the hash before b is modified.
+func appendSum(b []byte, h hash.Hash, data ...[]byte) []byte {
 	h.Reset()
 	for _, d := range data {
 		// the Hash interface specifies that Write never returns an error
 		_, _ = h.Write(d)
 	}
-	return h.Sum(nil)
+	return h.Sum(b)
 }
 
 // leafSum returns the hash created from data inserted to form a leaf. Leaf
@@ -73,18 +99,14 @@ func nodeSum(h hash.Hash, a, b []byte) []byte {
 }
 
 // joinSubTrees combines two equal sized subTrees into a larger subTree.
-func joinSubTrees(h hash.Hash, a, b *subTree) *subTree {
+func joinSubTrees(h hash.Hash, a, b subTree) subTree {
 	if DEBUG {
-		if b.next != a {
-			panic("invalid subtree join - 'a' is not paired with 'b'")
-		}
 		if a.height < b.height {
 			panic("invalid subtree presented - height mismatch")
 		}
 	}
 
-	return &subTree{
-		next:   a.next,
+	return subTree{
 		height: a.height + 1,
 		sum:    nodeSum(h, a.sum, b.sum),
 	}
@@ -94,7 +116,62 @@ func joinSubTrees(h hash.Hash, a, b *subTree) *subTree {
 // operations within the Tree.
 func New(h hash.Hash) *Tree {
 	return &Tree{
-		hash: h,
+		// preallocate a stack large enough for most trees
+		stack: make([]subTree, 0, 32),
+		hash:  h,
+	}
+}
+
+// reset empties the Tree, leaving it in the same state as a Tree returned by
+// New (or NewCachedTree, for a cached tree). The stack and its sum buffers
+// are kept for reuse, so that pushing the same number of leaves again does
+// not allocate.
+func (t *Tree) reset() {
+	t.stack = t.stack[:0]
+	t.currentIndex = 0
+	t.proofIndex = 0
+	if t.proofTree {
+		// Prove shares the elements of the proof set with its caller.
+		t.proofSet = nil
+	} else {
+		// The proof set of a Tree without a proof index is never returned,
+		// so its buffers can be reused.
+		t.proofSet = t.proofSet[:0]
+	}
+	t.proofTree = false
+	t.proofRanges = nil
+	t.rangeIndex = 0
+	t.rangeLeaf = 0
+	t.rangeHeight = 0
+	t.rangeProof = nil
+}
+
+// pushStack pushes a subtree of the specified height onto the stack and
+// returns it, reusing the sum buffer left behind by a previously popped
+// subtree if there is one. The caller is responsible for setting the sum.
+func (t *Tree) pushStack(height int) *subTree {
+	if len(t.stack) < cap(t.stack) {
+		t.stack = t.stack[:len(t.stack)+1]
+	} else {
+		t.stack = append(t.stack, subTree{})
+	}
+	st := &t.stack[len(t.stack)-1]
+	st.height = height
+	if st.sum == nil {
+		st.sum = make([]byte, 0, t.hash.Size())
+	}
+	return st
+}
+
+// addProofElem appends a copy of b to the proof set, reusing the buffer left
+// behind by reset if there is one.
+func (t *Tree) addProofElem(b []byte) {
+	n := len(t.proofSet)
+	if n < cap(t.proofSet) {
+		t.proofSet = t.proofSet[:n+1]
+		t.proofSet[n] = append(t.proofSet[n][:0], b...)
+	} else {
+		t.proofSet = append(t.proofSet, append([]byte(nil), b...))
 	}
 }
 
@@ -109,10 +186,10 @@ func (t *Tree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64,
 
 	// Return nil if the Tree is empty, or if the proofIndex hasn't yet been
 	// reached.
-	if t.head == nil || len(t.proofSet) == 0 {
+	if len(t.stack) == 0 || len(t.proofSet) == 0 {
 		return t.Root(), nil, t.proofIndex, t.currentIndex
 	}
-	proofSet = t.proofSet
+	proofSet = append([][]byte(nil), t.proofSet...)
 
 	// The set of subtrees must now be collapsed into a single root. The proof
 	// set already contains all of the elements that are members of a complete
@@ -130,15 +207,16 @@ func (t *Tree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64,
 	// can recognize the subtree containing the proof index because the height
 	// of that subtree will be one less than the current length of the proof
 	// set.
-	current := t.head
-	for current.next != nil && current.next.height < len(proofSet)-1 {
-		current = joinSubTrees(t.hash, current.next, current)
+	i := len(t.stack) - 1
+	current := t.stack[i]
+	for i--; i >= 0 && t.stack[i].height < len(proofSet)-1; i-- {
+		current = joinSubTrees(t.hash, t.stack[i], current)
 	}
 
-	// Sanity check - check that either 'current' or 'current.next' is the
+	// Sanity check - check that either 'current' or the next subtree is the
 	// subtree containing the proof index.
 	if DEBUG {
-		if current.height != len(t.proofSet)-1 && (current.next != nil && current.next.height != len(t.proofSet)-1) {
+		if current.height != len(t.proofSet)-1 && (i >= 0 && t.stack[i].height != len(t.proofSet)-1) {
 			panic("could not find the subtree containing the proof index")
 		}
 	}
@@ -147,25 +225,34 @@ func (t *Tree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64,
 	// then it must be an aggregate subtree that is to the right of the subtree
 	// containing the proof index, and the next subtree is the subtree
 	// containing the proof index.
-	if current.next != nil && current.next.height == len(proofSet)-1 {
-		proofSet = append(proofSet, current.sum)
-		current = current.next
+	if i >= 0 && t.stack[i].height == len(proofSet)-1 {
+		proofSet = append(proofSet, append([]byte(nil), current.sum...))
+		i--
 	}
 
 	// The current subtree must be the subtree containing the proof index. This
 	// subtree does not need an entry, as the entry was created during the
 	// construction of the Tree. Instead, skip to the next subtree.
-	current = current.next
-
+	//
 	// All remaining subtrees will be added to the proof set as a left sibling,
-	// completing the proof set.
-	for current != nil {
-		proofSet = append(proofSet, current.sum)
-		current = current.next
+	// completing the proof set. Their sums are copied, because the buffers
+	// are reused as more leaves are pushed.
+	for ; i >= 0; i-- {
+		proofSet = append(proofSet, append([]byte(nil), t.stack[i].sum...))
 	}
 	return t.Root(), proofSet, t.proofIndex, t.currentIndex
 }
 
+// ProveChecked is the same as Prove, except that it returns ErrIndexNotSet
+// instead of panicking if SetIndex has not been called.
+func (t *Tree) ProveChecked() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64, err error) {
+	if !t.proofTree {
+		return nil, nil, 0, 0, ErrIndexNotSet
+	}
+	merkleRoot, proofSet, proofIndex, numLeaves = t.Prove()
+	return merkleRoot, proofSet, proofIndex, numLeaves, nil
+}
+
 // Push will add data to the set, building out the Merkle tree and Root. The
 // tree does not remember all elements that are added, instead only keeping the
 // log(n) elements that are necessary to build the Merkle root and keeping the
@@ -173,44 +260,29 @@ func (t *Tree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64,
 // Merkle tree.
 func (t *Tree) Push(data []byte) {
 	// The first element of a proof is the data at the proof index. If this
-	// data is being inserted at the proof index, it is added to the proof set.
+	// data is being inserted at the proof index, a copy of it is added to the
+	// proof set.
 	if t.currentIndex == t.proofIndex {
-		t.proofSet = append(t.proofSet, data)
+		t.addProofElem(data)
 	}
 
 	// Hash the data to create a subtree of height 0. The sum of the new node
 	// is going to be the data for cached trees, and is going to be the result
 	// of calling leafSum() on the data for standard trees. Doing a check here
 	// prevents needing to duplicate the entire 'Push' function for the trees.
-	t.head = &subTree{
-		next:   t.head,
-		height: 0,
-	}
+	st := t.pushStack(0)
 	if t.cachedTree {
-		t.head.sum = data
+		st.sum = append(st.sum[:0], data...)
 	} else {
-		t.head.sum = leafSum(t.hash, data)
+		st.sum = appendSum(st.sum[:0], t.hash, leafHashPrefix, data)
 	}
+	t.addRangeProofNode(t.currentIndex, 0, st.sum)
 
 	// Join subTrees if possible.
 	t.joinAllSubTrees()
 
 	// Update the index.
 	t.currentIndex++
-
-	// Sanity check - From head to tail of the stack, the height should be
-	// strictly increasing.
-	if DEBUG {
-		current := t.head
-		height := current.height
-		for current.next != nil {
-			current = current.next
-			if current.height <= height {
-				panic("subtrees are out of order")
-			}
-			height = current.height
-		}
-	}
 }
 
 // PushSubTree pushes a cached subtree into the merkle tree. The subtree has to
@@ -227,64 +299,63 @@ func (t *Tree) PushSubTree(height int, sum []byte) error {
 		(t.currentIndex < t.proofIndex && t.proofIndex < newIndex)) {
 		return errors.New("the cached tree shouldn't contain the element to prove")
 	}
+	if !t.canPushRangeSubTree(t.currentIndex, newIndex) {
+		return errors.New("the cached tree can't partially overlap a proof range")
+	}
 
 	// We can only add the cached tree if its depth is <= the depth of the
 	// current subtree.
-	if t.head != nil && height > t.head.height {
-		return fmt.Errorf("can't add a subtree that is larger than the smallest subtree %v > %v", height, t.head.height)
+	if len(t.stack) != 0 && height > t.stack[len(t.stack)-1].height {
+		return fmt.Errorf("can't add a subtree that is larger than the smallest subtree %v > %v", height, t.stack[len(t.stack)-1].height)
 	}
 
 	// Insert the cached tree as the new head.
-	t.head = &subTree{
-		height: height,
-		next:   t.head,
-		sum:    sum,
-	}
+	st := t.pushStack(height)
+	st.sum = append(st.sum[:0], sum...)
+	t.addRangeProofNode(t.currentIndex, height, st.sum)
 
 	// Join subTrees if possible.
 	t.joinAllSubTrees()
 
 	// Update the index.
 	t.currentIndex = newIndex
-
-	// Sanity check - From head to tail of the stack, the height should be
-	// strictly increasing.
-	if DEBUG {
-		current := t.head
-		height := current.height
-		for current.next != nil {
-			current = current.next
-			if current.height <= height {
-				panic("subtrees are out of order")
-			}
-			height = current.height
-		}
-	}
 	return nil
 }
 
 // Root returns the Merkle root of the data that has been pushed.
 func (t *Tree) Root() []byte {
 	// If the Tree is empty, return nil.
-	if t.head == nil {
+	if len(t.stack) == 0 {
 		return nil
 	}
+	// Return a copy to prevent leaking a pointer to internal data.
+	return t.AppendRoot(make([]byte, 0, t.hash.Size()))
+}
+
+// AppendRoot appends the Merkle root of the data in the Tree to b and returns
+// the resulting slice, in the same way as hash.Hash.Sum. If the Tree is
+// empty, b is returned unchanged. Unlike Root, AppendRoot does not allocate
+// if b has enough capacity.
+func (t *Tree) AppendRoot(b []byte) []byte {
+	if len(t.stack) == 0 {
+		return b
+	}
 
 	// The root is formed by hashing together subTrees in order from least in
 	// height to greatest in height. The taller subtree is the first subtree in
 	// the join.
-	current := t.head
-	for current.next != nil {
-		current = joinSubTrees(t.hash, current.next, current)
+	current := t.stack[len(t.stack)-1].sum
+	for i := len(t.stack) - 2; i >= 0; i-- {
+		t.rootBuf = appendSum(t.rootBuf[:0], t.hash, nodeHashPrefix, t.stack[i].sum, current)
+		current = t.rootBuf
 	}
-	// Return a copy to prevent leaking a pointer to internal data.
-	return append(current.sum[:0:0], current.sum...)
+	return append(b, current...)
 }
 
 // SetIndex will tell the Tree to create a storage proof for the leaf at the
 // input index. SetIndex must be called on an empty tree.
 func (t *Tree) SetIndex(i uint64) error {
-	if t.head != nil {
+	if len(t.stack) != 0 {
 		return errors.New("cannot call SetIndex on Tree if Tree has not been reset")
 	}
 	t.proofTree = true
@@ -296,25 +367,32 @@ func (t *Tree) SetIndex(i uint64) error {
 // height of the next subTree is the same as the height of the current subTree,
 // the two will be combined into a single subTree of height n+1.
 func (t *Tree) joinAllSubTrees() {
-	for t.head.next != nil && t.head.height == t.head.next.height {
+	for len(t.stack) > 1 && t.stack[len(t.stack)-1].height == t.stack[len(t.stack)-2].height {
+		i := len(t.stack) - 1
+		j := len(t.stack) - 2
+
 		// Before combining subtrees, check whether one of the subtree hashes
 		// needs to be added to the proof set. This is going to be true IFF the
 		// subtrees being combined are one height higher than the previous
 		// subtree added to the proof set. The height of the previous subtree
 		// added to the proof set is equal to len(t.proofSet) - 1.
-		if t.head.height == len(t.proofSet)-1 {
+		//
+		// The subtrees being compared are the smallest and rightmost trees in
+		// the Tree, so the leftmost leaf of the right subtree can be found by
+		// rounding the currentIndex down to the number of leaves in the
+		// subtree.
+		leaves := uint64(1 << uint(t.stack[i].height))
+		mid := (t.currentIndex / leaves) * leaves
+		if t.stack[i].height == len(t.proofSet)-1 {
 			// One of the subtrees needs to be added to the proof set. The
 			// subtree that needs to be added is the subtree that does not
-			// contain the proofIndex. Because the subtrees being compared are
-			// the smallest and rightmost trees in the Tree, this can be
-			// determined by rounding the currentIndex down to the number of
-			// nodes in the subtree and comparing that index to the proofIndex.
-			leaves := uint64(1 << uint(t.head.height))
-			mid := (t.currentIndex / leaves) * leaves
+			// contain the proofIndex, which is determined by comparing the
+			// midpoint to the proofIndex. The sum is copied, because the
+			// buffer is reused as more leaves are pushed.
 			if t.proofIndex < mid {
-				t.proofSet = append(t.proofSet, t.head.sum)
+				t.addProofElem(t.stack[i].sum)
 			} else {
-				t.proofSet = append(t.proofSet, t.head.next.sum)
+				t.addProofElem(t.stack[j].sum)
 			}
 
 			// Sanity check - the proofIndex should never be less than the
@@ -326,8 +404,21 @@ func (t *Tree) joinAllSubTrees() {
 			}
 		}
 
-		// Join the two subTrees into one subTree with a greater height. Then
-		// compare the new subTree to the next subTree.
-		t.head = joinSubTrees(t.hash, t.head.next, t.head)
+		// Join the two subTrees into one subTree with a greater height, in
+		// place. Then compare the new subTree to the next subTree.
+		t.stack[j].sum = appendSum(t.stack[j].sum[:0], t.hash, nodeHashPrefix, t.stack[j].sum, t.stack[i].sum)
+		t.stack[j].height++
+		t.stack = t.stack[:i]
+		t.addRangeProofNode(mid-leaves, t.stack[j].height, t.stack[j].sum)
+	}
+
+	// Sanity check - From head to tail of the stack, the height should be
+	// strictly decreasing.
+	if DEBUG {
+		for i := range t.stack[1:] {
+			if t.stack[i].height <= t.stack[i+1].height {
+				panic("subtrees are out of order")
+			}
+		}
 	}
 }
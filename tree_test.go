@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"io"
 	"math/big"
 	"strconv"
 	"testing"
@@ -762,6 +763,41 @@ func TestPushSubTreeSimple(t *testing.T) {
 	}
 }
 
+// TestTreeAllocs tests that pushing leaves and subtrees into a Tree does not
+// allocate once the stack has grown to its final height, and that Root only
+// allocates the returned copy of the root.
+func TestTreeAllocs(t *testing.T) {
+	data := make([]byte, 64)
+	tree := New(sha256.New())
+	// grow the stack and its sum buffers
+	for i := 0; i < 1<<10; i++ {
+		tree.Push(data)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { tree.Push(data) }); allocs != 0 {
+		t.Errorf("Push allocated %v times", allocs)
+	}
+	sum := tree.Root()
+	if allocs := testing.AllocsPerRun(100, func() { tree.PushSubTree(0, sum) }); allocs != 0 {
+		t.Errorf("PushSubTree allocated %v times", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { tree.Root() }); allocs > 1 {
+		t.Errorf("Root allocated %v times", allocs)
+	}
+	root := make([]byte, 0, sha256.Size)
+	if allocs := testing.AllocsPerRun(100, func() { root = tree.AppendRoot(root[:0]) }); allocs != 0 {
+		t.Errorf("AppendRoot allocated %v times", allocs)
+	} else if !bytes.Equal(root, tree.Root()) {
+		t.Error("AppendRoot returned the wrong root")
+	}
+	r := bytes.NewReader(make([]byte, 64*100))
+	if allocs := testing.AllocsPerRun(100, func() {
+		r.Seek(0, io.SeekStart)
+		tree.ReadAll(r, 64)
+	}); allocs != 0 {
+		t.Errorf("ReadAll allocated %v times", allocs)
+	}
+}
+
 // BenchmarkSha256_4MB uses sha256 to hash 4mb of data.
 func BenchmarkSha256_4MB(b *testing.B) {
 	data := make([]byte, 4*1024*1024)
@@ -786,6 +822,7 @@ func BenchmarkTree64_4MB(b *testing.B) {
 	}
 	segmentSize := 64
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	tree := New(sha256.New())
 	for i := 0; i < b.N; i++ {
@@ -806,6 +843,7 @@ func BenchmarkTree4k_4MB(b *testing.B) {
 	}
 	segmentSize := 4096
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	tree := New(sha256.New())
 	for i := 0; i < b.N; i++ {
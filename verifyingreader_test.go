@@ -0,0 +1,165 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/uplo-tech/fastrand"
+)
+
+// TestVerifyingReader tests that a VerifyingReader returns the data of a
+// range proof, and that it returns none of the data of a corrupt range.
+func TestVerifyingReader(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	for numLeaves := 1; numLeaves < 40; numLeaves++ {
+		// the last leaf is sometimes partial
+		data := fastrand.Bytes(numLeaves*leafSize - fastrand.Intn(leafSize))
+		root := bytesRoot(data, h, leafSize)
+		var leafHashes [][]byte
+		for i := 0; i < len(data); i += leafSize {
+			leaf := data[i:]
+			if len(leaf) > leafSize {
+				leaf = leaf[:leafSize]
+			}
+			leafHashes = append(leafHashes, leafSum(h, leaf))
+		}
+		for n := 0; n < 5; n++ {
+			start := fastrand.Intn(numLeaves)
+			end := start + 1 + fastrand.Intn(numLeaves-start)
+			rangeData := data[start*leafSize:]
+			if len(rangeData) > (end-start)*leafSize {
+				rangeData = rangeData[:(end-start)*leafSize]
+			}
+
+			proof, err := BuildRangeProof(start, end, NewCachedSubtreeHasher(leafHashes, h))
+			if err != nil {
+				t.Fatal(err)
+			}
+			vr, err := NewVerifyingReader(bytes.NewReader(rangeData), h, leafSize, start, end, proof, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err := ioutil.ReadAll(vr)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(read, rangeData) {
+				t.Fatal("VerifyingReader returned the wrong data")
+			}
+
+			// corrupt a random byte of the range
+			corrupt := append([]byte(nil), rangeData...)
+			corrupt[fastrand.Intn(len(corrupt))] ^= 1
+			vr, err = NewVerifyingReader(bytes.NewReader(corrupt), h, leafSize, start, end, proof, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err = ioutil.ReadAll(vr)
+			if cse, ok := err.(*CorruptSubtreeError); !ok {
+				t.Fatal("expected CorruptSubtreeError, got", err)
+			} else if cse.Start != uint64(start) || cse.End != uint64(end) {
+				t.Fatalf("expected corrupt range [%v,%v), got [%v,%v)", start, end, cse.Start, cse.End)
+			} else if len(read) != 0 {
+				t.Fatal("VerifyingReader returned data from a corrupt range")
+			}
+
+			// a truncated stream is an error
+			vr, err = NewVerifyingReader(bytes.NewReader(rangeData[:len(rangeData)-1]), h, leafSize, start, end, proof, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ioutil.ReadAll(vr); err == nil {
+				t.Fatal("expected error for a truncated stream")
+			}
+		}
+	}
+}
+
+// TestVerifyingReaderInvalidProof tests that a VerifyingReader rejects
+// proofs that do not match the Merkle root.
+func TestVerifyingReaderInvalidProof(t *testing.T) {
+	const leafSize = 8
+	h := sha256.New()
+	data := fastrand.Bytes(20 * leafSize)
+	root := bytesRoot(data, h, leafSize)
+	proof, err := BuildRangeProof(3, 11, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rangeData := data[3*leafSize : 11*leafSize]
+	vr, err := NewVerifyingReader(bytes.NewReader(rangeData[:7*leafSize]), h, leafSize, 3, 11, proof, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(vr); err != io.ErrUnexpectedEOF {
+		t.Error("expected io.ErrUnexpectedEOF when a leaf is missing, got", err)
+	}
+	for _, badProof := range [][][]byte{
+		proof[:len(proof)-1],
+		append(proof, proof[0]),
+		append([][]byte{proof[1], proof[0]}, proof[2:]...),
+	} {
+		vr, err := NewVerifyingReader(bytes.NewReader(rangeData), h, leafSize, 3, 11, badProof, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(vr); err == nil {
+			t.Error("expected error for an invalid proof")
+		}
+	}
+	vr, err = NewVerifyingReader(bytes.NewReader(rangeData), h, leafSize, 3, 11, proof, proof[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(vr); err == nil {
+		t.Error("expected error for the wrong root")
+	}
+	if _, err := NewVerifyingReader(bytes.NewReader(rangeData), h, leafSize, 3, 11, proof[:1], root); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort, got", err)
+	}
+	if _, err := NewVerifyingReader(bytes.NewReader(rangeData), h, leafSize, 3, 3, proof, root); err != ErrInvalidRange {
+		t.Error("expected ErrInvalidRange, got", err)
+	}
+}
+
+// TestVerifyingReaderHoldsBack tests that a VerifyingReader does not return
+// any data before it has read the whole range.
+func TestVerifyingReaderHoldsBack(t *testing.T) {
+	const leafSize = 64
+	h := sha256.New()
+	data := fastrand.Bytes(1 << 16)
+	root := bytesRoot(data, h, leafSize)
+	numLeaves := len(data) / leafSize
+	proof, err := BuildRangeProof(0, numLeaves, NewReaderSubtreeHasher(bytes.NewReader(data), leafSize, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &countingReader{r: bytes.NewReader(data)}
+	vr, err := NewVerifyingReader(r, h, leafSize, 0, numLeaves, proof, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 100)
+	if n, err := vr.Read(buf); err != nil {
+		t.Fatal(err)
+	} else if r.bytes != len(data) {
+		t.Fatalf("VerifyingReader returned data after reading %v of %v bytes", r.bytes, len(data))
+	} else if !bytes.Equal(buf[:n], data[:n]) {
+		t.Fatal("VerifyingReader returned the wrong data")
+	}
+}
+
+// countingReader is an io.Reader that counts the bytes read from it.
+type countingReader struct {
+	r     io.Reader
+	bytes int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.bytes += n
+	return n, err
+}